@@ -64,7 +64,41 @@ func init() {
 	cfg.SetDefault("storage.elasticsearch.host", "127.0.0.1:9200")
 	cfg.SetDefault("storage.elasticsearch.maxconns", 10)
 	cfg.SetDefault("storage.elasticsearch.retry", 60)
+	cfg.SetDefault("storage.elasticsearch.max_attempts", 3)
 	cfg.SetDefault("storage.elasticsearch.bulk_maxdocs", 0)
+	cfg.SetDefault("storage.elasticsearch.bulk_maxbytes", 0)
+	cfg.SetDefault("storage.elasticsearch.bulk_maxbuffer", 0)
+	cfg.SetDefault("storage.elasticsearch.bulk_flush_interval", "0s")
+	cfg.SetDefault("storage.elasticsearch.bulk_max_failures", 5)
+	cfg.SetDefault("storage.elasticsearch.drain_timeout", 5)
+	cfg.SetDefault("storage.elasticsearch.bulk_max_retries", 3)
+	cfg.SetDefault("storage.elasticsearch.bulk_retry_delay", 1)
+	cfg.SetDefault("storage.elasticsearch.max_pending", 0)
+	cfg.SetDefault("storage.elasticsearch.pending_policy", "block")
+	cfg.SetDefault("storage.elasticsearch.breaker_threshold", 0)
+	cfg.SetDefault("storage.elasticsearch.breaker_window", 10)
+	cfg.SetDefault("storage.elasticsearch.breaker_cooldown", 30)
+	cfg.SetDefault("storage.elasticsearch.index_prefix", "skydive")
+	cfg.SetDefault("storage.elasticsearch.version", 0)
+	cfg.SetDefault("storage.elasticsearch.shards", 0)
+	cfg.SetDefault("storage.elasticsearch.replicas", -1)
+	cfg.SetDefault("storage.elasticsearch.codec", "default")
+	cfg.SetDefault("storage.elasticsearch.analysis", "")
+	cfg.SetDefault("storage.elasticsearch.allow_scripts", false)
+	cfg.SetDefault("storage.elasticsearch.keyword_fields", []string{})
+	cfg.SetDefault("storage.elasticsearch.dynamic_mapping", map[string]string{})
+	cfg.SetDefault("storage.elasticsearch.index_template", false)
+	cfg.SetDefault("storage.elasticsearch.rolling_index", false)
+	cfg.SetDefault("storage.elasticsearch.index_per_type", false)
+	cfg.SetDefault("storage.elasticsearch.health_status", "yellow")
+	cfg.SetDefault("storage.elasticsearch.health_timeout", 30)
+	cfg.SetDefault("storage.elasticsearch.max_retry_delay", 30)
+	cfg.SetDefault("storage.elasticsearch.connect_timeout", 0)
+	cfg.SetDefault("storage.elasticsearch.request_timeout", 0)
+	cfg.SetDefault("storage.elasticsearch.opaque_id", "")
+	cfg.SetDefault("storage.elasticsearch.compress_requests", false)
+	cfg.SetDefault("storage.elasticsearch.max_idle_conns", 100)
+	cfg.SetDefault("storage.elasticsearch.max_conns_per_host", 0)
 	cfg.SetDefault("ws_pong_timeout", 5)
 	cfg.SetDefault("docker.url", "unix:///var/run/docker.sock")
 	cfg.SetDefault("netns.run_path", "/var/run/netns")
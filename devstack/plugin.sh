@@ -15,8 +15,8 @@
 # The path where go binaries have to be installed
 GOROOT=${GOROOT:-/opt/go}
 
-# golang version. Skydive needs at least version 1.6
-GO_VERSION=${GO_VERSION:-1.6}
+# golang version. Skydive needs at least version 1.17
+GO_VERSION=${GO_VERSION:-1.17}
 
 # GOPATH where the go src, pkgs are installed
 GOPATH=/opt/stack/go
@@ -86,6 +86,7 @@ function install_go {
     export GOROOT=$GOROOT
     export PATH=$PATH:$GOROOT/bin:$GOPATH/bin
     export GOPATH=$GOPATH
+    export GO111MODULE=off
 }
 
 function download_elasticsearch {
@@ -0,0 +1,169 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package filters
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateLayouts are the date formats accepted as date math anchors
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// ParseDateMath evaluates an elasticsearch date math expression such as
+// now-15m or 2017-03-05||+1d/d. The rounded values are rounded down unless
+// roundUp is set, as for the upper bound of an inclusive range.
+func ParseDateMath(expr string, now time.Time, roundUp bool) (time.Time, error) {
+	var t time.Time
+	var ops string
+
+	if strings.HasPrefix(expr, "now") {
+		t, ops = now, expr[len("now"):]
+	} else {
+		anchor := expr
+		if i := strings.Index(expr, "||"); i >= 0 {
+			anchor, ops = expr[:i], expr[i+2:]
+		}
+
+		var err error
+		if t, err = parseDate(anchor); err != nil {
+			return time.Time{}, fmt.Errorf("invalid date %s in %s", anchor, expr)
+		}
+	}
+
+	for ops != "" {
+		op := ops[0]
+		ops = ops[1:]
+
+		switch op {
+		case '+', '-':
+			i := 0
+			for i < len(ops) && ops[i] >= '0' && ops[i] <= '9' {
+				i++
+			}
+			if i == 0 || i == len(ops) {
+				return time.Time{}, fmt.Errorf("invalid date math %s", expr)
+			}
+
+			n, _ := strconv.Atoi(ops[:i])
+			if op == '-' {
+				n = -n
+			}
+
+			var err error
+			if t, err = addDateUnit(t, n, ops[i]); err != nil {
+				return time.Time{}, fmt.Errorf("%s in %s", err.Error(), expr)
+			}
+			ops = ops[i+1:]
+		case '/':
+			if ops == "" {
+				return time.Time{}, fmt.Errorf("invalid date math %s", expr)
+			}
+
+			var err error
+			if t, err = roundDate(t, ops[0], roundUp); err != nil {
+				return time.Time{}, fmt.Errorf("%s in %s", err.Error(), expr)
+			}
+			ops = ops[1:]
+		default:
+			return time.Time{}, fmt.Errorf("invalid date math %s", expr)
+		}
+	}
+
+	return t, nil
+}
+
+func parseDate(value string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+
+	// epoch in seconds, as the skydive timestamps mapped as epoch_second
+	s, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(s, 0).UTC(), nil
+}
+
+func addDateUnit(t time.Time, n int, unit byte) (time.Time, error) {
+	switch unit {
+	case 'y':
+		return t.AddDate(n, 0, 0), nil
+	case 'M':
+		return t.AddDate(0, n, 0), nil
+	case 'w':
+		return t.AddDate(0, 0, 7*n), nil
+	case 'd':
+		return t.AddDate(0, 0, n), nil
+	case 'h', 'H':
+		return t.Add(time.Duration(n) * time.Hour), nil
+	case 'm':
+		return t.Add(time.Duration(n) * time.Minute), nil
+	case 's':
+		return t.Add(time.Duration(n) * time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date unit %c", unit)
+}
+
+// roundDate rounds in UTC, as Elasticsearch does without a time zone
+func roundDate(t time.Time, unit byte, roundUp bool) (time.Time, error) {
+	t = t.UTC()
+
+	var start time.Time
+	switch unit {
+	case 'y':
+		start = time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	case 'M':
+		start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case 'w':
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		// weeks start on monday
+		start = day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case 'd':
+		start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case 'h', 'H':
+		start = t.Truncate(time.Hour)
+	case 'm':
+		start = t.Truncate(time.Minute)
+	case 's':
+		start = t.Truncate(time.Second)
+	default:
+		return time.Time{}, fmt.Errorf("invalid date unit %c", unit)
+	}
+
+	if !roundUp {
+		return start, nil
+	}
+
+	// the last millisecond of the unit
+	end, _ := addDateUnit(start, 1, unit)
+	return end.Add(-time.Millisecond), nil
+}
@@ -22,11 +22,39 @@
 
 package filters
 
-import "regexp"
+import (
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// earthRadius is the mean radius of the Earth in meters
+const earthRadius = 6371008.8
+
+// distanceUnits are the distance units supported by Elasticsearch with
+// their length in meters
+var distanceUnits = map[string]float64{
+	"mi": 1609.344, "miles": 1609.344,
+	"yd": 0.9144, "yards": 0.9144,
+	"ft": 0.3048, "feet": 0.3048,
+	"in": 0.0254, "inch": 0.0254,
+	"km": 1000, "kilometers": 1000,
+	"m": 1, "meters": 1,
+	"cm": 0.01, "centimeters": 0.01,
+	"mm": 0.001, "millimeters": 0.001,
+	"NM": 1852, "nmi": 1852, "nauticalmiles": 1852,
+}
 
 type Getter interface {
 	GetFieldInt64(field string) (int64, error)
+	GetFieldFloat64(field string) (float64, error)
 	GetFieldString(field string) (string, error)
+	GetFieldBool(field string) (bool, error)
 }
 
 func (f *Filter) Eval(g Getter) bool {
@@ -39,6 +67,9 @@ func (f *Filter) Eval(g Getter) bool {
 	if f.TermInt64Filter != nil {
 		return f.TermInt64Filter.Eval(g)
 	}
+	if f.TermBoolFilter != nil {
+		return f.TermBoolFilter.Eval(g)
+	}
 	if f.GtInt64Filter != nil {
 		return f.GtInt64Filter.Eval(g)
 	}
@@ -51,9 +82,63 @@ func (f *Filter) Eval(g Getter) bool {
 	if f.LteInt64Filter != nil {
 		return f.LteInt64Filter.Eval(g)
 	}
+	if f.GtFloat64Filter != nil {
+		return f.GtFloat64Filter.Eval(g)
+	}
+	if f.LtFloat64Filter != nil {
+		return f.LtFloat64Filter.Eval(g)
+	}
+	if f.GteFloat64Filter != nil {
+		return f.GteFloat64Filter.Eval(g)
+	}
+	if f.LteFloat64Filter != nil {
+		return f.LteFloat64Filter.Eval(g)
+	}
+	if f.RangeFilter != nil {
+		return f.RangeFilter.Eval(g)
+	}
+	if f.NestedFilter != nil {
+		return f.NestedFilter.Eval(g)
+	}
+	if f.TermsStringFilter != nil {
+		return f.TermsStringFilter.Eval(g)
+	}
+	if f.TermsInt64Filter != nil {
+		return f.TermsInt64Filter.Eval(g)
+	}
+	if f.IPRangeFilter != nil {
+		return f.IPRangeFilter.Eval(g)
+	}
+	if f.ScriptFilter != nil {
+		return f.ScriptFilter.Eval(g)
+	}
+	if f.ArrayLenFilter != nil {
+		return f.ArrayLenFilter.Eval(g)
+	}
 	if f.RegexFilter != nil {
 		return f.RegexFilter.Eval(g)
 	}
+	if f.ExistsFilter != nil {
+		return f.ExistsFilter.Eval(g)
+	}
+	if f.NotExistsFilter != nil {
+		return f.NotExistsFilter.Eval(g)
+	}
+	if f.GeoDistanceFilter != nil {
+		return f.GeoDistanceFilter.Eval(g)
+	}
+	if f.MatchFilter != nil {
+		return f.MatchFilter.Eval(g)
+	}
+	if f.DateRangeFilter != nil {
+		return f.DateRangeFilter.Eval(g)
+	}
+	if f.PrefixFilter != nil {
+		return f.PrefixFilter.Eval(g)
+	}
+	if f.WildcardFilter != nil {
+		return f.WildcardFilter.Eval(g)
+	}
 
 	return true
 }
@@ -121,6 +206,91 @@ func (r *LteInt64Filter) Eval(g Getter) bool {
 	return false
 }
 
+func (r *GtFloat64Filter) Eval(g Getter) bool {
+	field, err := g.GetFieldFloat64(r.Key)
+	if err != nil {
+		return false
+	}
+
+	return field > r.Value
+}
+
+func (r *LtFloat64Filter) Eval(g Getter) bool {
+	field, err := g.GetFieldFloat64(r.Key)
+	if err != nil {
+		return false
+	}
+
+	return field < r.Value
+}
+
+func (r *GteFloat64Filter) Eval(g Getter) bool {
+	field, err := g.GetFieldFloat64(r.Key)
+	if err != nil {
+		return false
+	}
+
+	return field >= r.Value
+}
+
+func (r *LteFloat64Filter) Eval(g Getter) bool {
+	field, err := g.GetFieldFloat64(r.Key)
+	if err != nil {
+		return false
+	}
+
+	return field <= r.Value
+}
+
+func (r *RangeFilter) Eval(g Getter) bool {
+	field, err := g.GetFieldInt64(r.Key)
+	if err != nil {
+		return false
+	}
+
+	if r.Gt != nil && field <= r.Gt.Value {
+		return false
+	}
+	if r.Gte != nil && field < r.Gte.Value {
+		return false
+	}
+	if r.Lt != nil && field >= r.Lt.Value {
+		return false
+	}
+	if r.Lte != nil && field > r.Lte.Value {
+		return false
+	}
+	return true
+}
+
+type nestedGetter struct {
+	getter Getter
+	prefix string
+}
+
+func (n *nestedGetter) GetFieldInt64(field string) (int64, error) {
+	return n.getter.GetFieldInt64(n.prefix + field)
+}
+
+func (n *nestedGetter) GetFieldFloat64(field string) (float64, error) {
+	return n.getter.GetFieldFloat64(n.prefix + field)
+}
+
+func (n *nestedGetter) GetFieldString(field string) (string, error) {
+	return n.getter.GetFieldString(n.prefix + field)
+}
+
+func (n *nestedGetter) GetFieldBool(field string) (bool, error) {
+	return n.getter.GetFieldBool(n.prefix + field)
+}
+
+func (n *NestedFilter) Eval(g Getter) bool {
+	if n.Filter == nil {
+		return true
+	}
+	return n.Filter.Eval(&nestedGetter{getter: g, prefix: n.Path + "."})
+}
+
 func (t *TermStringFilter) Eval(g Getter) bool {
 	field, err := g.GetFieldString(t.Key)
 	if err != nil {
@@ -130,6 +300,69 @@ func (t *TermStringFilter) Eval(g Getter) bool {
 	return field == t.Value
 }
 
+// words splits a text into lower case words, roughly as the standard
+// analyzer of Elasticsearch does
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Eval matches the field if it contains any of the words of the value, or
+// all of them with the and operator
+func (m *MatchFilter) Eval(g Getter) bool {
+	field, err := g.GetFieldString(m.Key)
+	if err != nil {
+		return false
+	}
+
+	fieldWords := make(map[string]bool)
+	for _, word := range words(field) {
+		fieldWords[word] = true
+	}
+
+	values := words(m.Value)
+	if len(values) == 0 {
+		return false
+	}
+
+	all := strings.ToLower(m.Operator) == "and"
+	for _, word := range values {
+		if fieldWords[word] != all {
+			return !all
+		}
+	}
+	return all
+}
+
+func (t *TermsStringFilter) Eval(g Getter) bool {
+	field, err := g.GetFieldString(t.Key)
+	if err != nil {
+		return false
+	}
+
+	for _, value := range t.Values {
+		if field == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *TermsInt64Filter) Eval(g Getter) bool {
+	field, err := g.GetFieldInt64(t.Key)
+	if err != nil {
+		return false
+	}
+
+	for _, value := range t.Values {
+		if field == value {
+			return true
+		}
+	}
+	return false
+}
+
 func (t *TermInt64Filter) Eval(g Getter) bool {
 	field, err := g.GetFieldInt64(t.Key)
 	if err != nil {
@@ -139,6 +372,176 @@ func (t *TermInt64Filter) Eval(g Getter) bool {
 	return field == t.Value
 }
 
+func (t *TermBoolFilter) Eval(g Getter) bool {
+	field, err := g.GetFieldBool(t.Key)
+	if err != nil {
+		return false
+	}
+
+	return field == t.Value
+}
+
+// Bounds returns the first and the last addresses of the network
+func (r *IPRangeFilter) Bounds() (net.IP, net.IP, error) {
+	_, network, err := net.ParseCIDR(r.CIDR)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	last := make(net.IP, len(network.IP))
+	for i := range network.IP {
+		last[i] = network.IP[i] | ^network.Mask[i]
+	}
+	return network.IP, last, nil
+}
+
+// anyOctet matches the decimal notation of any byte
+const anyOctet = "(25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])"
+
+// Regexp returns a regular expression matching the textual notation of the
+// addresses of the network, for the fields not mapped with the ip type as
+// their values can't be compared to the bounds of the network. Only IPv4
+// networks are supported as IPv6 addresses have several notations.
+func (r *IPRangeFilter) Regexp() (string, error) {
+	first, last, err := r.Bounds()
+	if err != nil {
+		return "", err
+	}
+
+	if first.To4() == nil {
+		return "", fmt.Errorf("IPv6 network %s can only be matched on ip fields", r.CIDR)
+	}
+	first, last = first.To4(), last.To4()
+
+	octets := make([]string, 4)
+	for i := range octets {
+		switch {
+		case first[i] == last[i]:
+			octets[i] = strconv.Itoa(int(first[i]))
+		case first[i] == 0 && last[i] == 255:
+			octets[i] = anyOctet
+		default:
+			values := make([]string, 0, int(last[i]-first[i])+1)
+			for v := int(first[i]); v <= int(last[i]); v++ {
+				values = append(values, strconv.Itoa(v))
+			}
+			octets[i] = "(" + strings.Join(values, "|") + ")"
+		}
+	}
+	return strings.Join(octets, `\.`), nil
+}
+
+func (r *IPRangeFilter) Eval(g Getter) bool {
+	field, err := g.GetFieldString(r.Key)
+	if err != nil {
+		return false
+	}
+
+	_, network, err := net.ParseCIDR(r.CIDR)
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(field)
+	return ip != nil && network.Contains(ip)
+}
+
+// Meters returns the distance of the filter in meters, an error is returned
+// if the coordinates are out of range or if the distance unit is unknown
+func (d *GeoDistanceFilter) Meters() (float64, error) {
+	if d.Lat < -90 || d.Lat > 90 {
+		return 0, fmt.Errorf("latitude %g out of range [-90, 90]", d.Lat)
+	}
+	if d.Lon < -180 || d.Lon > 180 {
+		return 0, fmt.Errorf("longitude %g out of range [-180, 180]", d.Lon)
+	}
+
+	number := strings.TrimRightFunc(d.Distance, func(r rune) bool {
+		return r < '0' || r > '9'
+	})
+	unit, found := distanceUnits[d.Distance[len(number):]]
+	if !found {
+		return 0, fmt.Errorf("invalid distance unit in %s", d.Distance)
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid distance %s", d.Distance)
+	}
+	return value * unit, nil
+}
+
+// Eval matches the fields holding either a timestamp in milliseconds or a
+// formatted date between the bounds, evaluated against the current time
+func (d *DateRangeFilter) Eval(g Getter) bool {
+	now := time.Now()
+	from, err := ParseDateMath(d.From, now, false)
+	if err != nil {
+		return false
+	}
+	to, err := ParseDateMath(d.To, now, true)
+	if err != nil {
+		return false
+	}
+
+	// the integer timestamps are in seconds, as mapped with epoch_second
+	var t time.Time
+	if s, err := g.GetFieldInt64(d.Key); err == nil {
+		t = time.Unix(s, 0)
+	} else if s, err := g.GetFieldString(d.Key); err == nil {
+		if t, err = parseDate(s); err != nil {
+			return false
+		}
+	} else {
+		return false
+	}
+
+	return !t.Before(from) && !t.After(to)
+}
+
+// Eval matches the fields holding a "lat,lon" point within the distance
+func (d *GeoDistanceFilter) Eval(g Getter) bool {
+	distance, err := d.Meters()
+	if err != nil {
+		return false
+	}
+
+	field, err := g.GetFieldString(d.Key)
+	if err != nil {
+		return false
+	}
+
+	coords := strings.Split(field, ",")
+	if len(coords) != 2 {
+		return false
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(coords[0]), 64)
+	if err != nil {
+		return false
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(coords[1]), 64)
+	if err != nil {
+		return false
+	}
+
+	// haversine formula
+	rad := math.Pi / 180
+	dLat, dLon := (lat-d.Lat)*rad, (lon-d.Lon)*rad
+	a := math.Pow(math.Sin(dLat/2), 2) + math.Cos(d.Lat*rad)*math.Cos(lat*rad)*math.Pow(math.Sin(dLon/2), 2)
+	return 2*earthRadius*math.Asin(math.Sqrt(a)) <= distance
+}
+
+// Eval always fails as scripts are only run by the storage backends
+func (s *ScriptFilter) Eval(g Getter) bool {
+	return false
+}
+
+// Eval always fails as the length of the arrays is computed by a script,
+// only run by the storage backends
+func (a *ArrayLenFilter) Eval(g Getter) bool {
+	return false
+}
+
 func (r *RegexFilter) Eval(g Getter) bool {
 	field, err := g.GetFieldString(r.Key)
 	if err != nil {
@@ -149,6 +552,50 @@ func (r *RegexFilter) Eval(g Getter) bool {
 	return re.MatchString(field)
 }
 
+func (p *PrefixFilter) Eval(g Getter) bool {
+	field, err := g.GetFieldString(p.Key)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(field, p.Value)
+}
+
+func (w *WildcardFilter) Eval(g Getter) bool {
+	field, err := g.GetFieldString(w.Key)
+	if err != nil {
+		return false
+	}
+
+	// translate the * and ? wildcards to their regex counterparts
+	expr := regexp.QuoteMeta(w.Value)
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+
+	re := regexp.MustCompile("^" + expr + "$")
+	return re.MatchString(field)
+}
+
+func (e *ExistsFilter) Eval(g Getter) bool {
+	if _, err := g.GetFieldString(e.Key); err == nil {
+		return true
+	}
+	if _, err := g.GetFieldInt64(e.Key); err == nil {
+		return true
+	}
+	if _, err := g.GetFieldFloat64(e.Key); err == nil {
+		return true
+	}
+	if _, err := g.GetFieldBool(e.Key); err == nil {
+		return true
+	}
+	return false
+}
+
+func (n *NotExistsFilter) Eval(g Getter) bool {
+	return !(&ExistsFilter{Key: n.Key}).Eval(g)
+}
+
 func NewBoolFilter(op BoolFilterOp, filters ...*Filter) *Filter {
 	boolFilter := &BoolFilter{
 		Op:      op,
@@ -176,6 +623,46 @@ func NewNotFilter(filter *Filter) *Filter {
 	return NewBoolFilter(BoolFilterOp_NOT, filter)
 }
 
+// And returns a filter matching all the given filters, the nil ones being
+// ignored and the nested And filters flattened. A single filter is returned
+// as is.
+func And(filters ...*Filter) *Filter {
+	return combine(BoolFilterOp_AND, filters)
+}
+
+// Or returns a filter matching any of the given filters, simplified as And
+func Or(filters ...*Filter) *Filter {
+	return combine(BoolFilterOp_OR, filters)
+}
+
+// Not returns a filter matching the documents not matched by filter, a
+// double negation returning the filter itself
+func Not(filter *Filter) *Filter {
+	if filter != nil && filter.BoolFilter != nil && filter.BoolFilter.Op == BoolFilterOp_NOT && len(filter.BoolFilter.Filters) == 1 {
+		return filter.BoolFilter.Filters[0]
+	}
+	return NewNotFilter(filter)
+}
+
+func combine(op BoolFilterOp, filters []*Filter) *Filter {
+	var flattened []*Filter
+	for _, filter := range filters {
+		if filter == nil {
+			continue
+		}
+		if filter.BoolFilter != nil && filter.BoolFilter.Op == op {
+			flattened = append(flattened, filter.BoolFilter.Filters...)
+		} else {
+			flattened = append(flattened, filter)
+		}
+	}
+
+	if len(flattened) == 1 {
+		return flattened[0]
+	}
+	return NewBoolFilter(op, flattened...)
+}
+
 func NewGtInt64Filter(key string, value int64) *Filter {
 	return &Filter{GtInt64Filter: &GtInt64Filter{Key: key, Value: value}}
 }
@@ -192,6 +679,50 @@ func NewLteInt64Filter(key string, value int64) *Filter {
 	return &Filter{LteInt64Filter: &LteInt64Filter{Key: key, Value: value}}
 }
 
+func NewGtFloat64Filter(key string, value float64) *Filter {
+	return &Filter{GtFloat64Filter: &GtFloat64Filter{Key: key, Value: value}}
+}
+
+func NewGteFloat64Filter(key string, value float64) *Filter {
+	return &Filter{GteFloat64Filter: &GteFloat64Filter{Key: key, Value: value}}
+}
+
+func NewLtFloat64Filter(key string, value float64) *Filter {
+	return &Filter{LtFloat64Filter: &LtFloat64Filter{Key: key, Value: value}}
+}
+
+func NewLteFloat64Filter(key string, value float64) *Filter {
+	return &Filter{LteFloat64Filter: &LteFloat64Filter{Key: key, Value: value}}
+}
+
+// RequireExists guards the range filter held by f with an existence check of
+// its field, the filter being returned for the calls to be chained
+func (f *Filter) RequireExists() *Filter {
+	switch {
+	case f.GtInt64Filter != nil:
+		f.GtInt64Filter.RequireExists = true
+	case f.LtInt64Filter != nil:
+		f.LtInt64Filter.RequireExists = true
+	case f.GteInt64Filter != nil:
+		f.GteInt64Filter.RequireExists = true
+	case f.LteInt64Filter != nil:
+		f.LteInt64Filter.RequireExists = true
+	case f.GtFloat64Filter != nil:
+		f.GtFloat64Filter.RequireExists = true
+	case f.LtFloat64Filter != nil:
+		f.LtFloat64Filter.RequireExists = true
+	case f.GteFloat64Filter != nil:
+		f.GteFloat64Filter.RequireExists = true
+	case f.LteFloat64Filter != nil:
+		f.LteFloat64Filter.RequireExists = true
+	case f.RangeFilter != nil:
+		f.RangeFilter.RequireExists = true
+	case f.DateRangeFilter != nil:
+		f.DateRangeFilter.RequireExists = true
+	}
+	return f
+}
+
 func NewTermInt64Filter(key string, value int64) *Filter {
 	return &Filter{TermInt64Filter: &TermInt64Filter{Key: key, Value: value}}
 }
@@ -200,6 +731,102 @@ func NewTermStringFilter(key string, value string) *Filter {
 	return &Filter{TermStringFilter: &TermStringFilter{Key: key, Value: value}}
 }
 
+func NewTermBoolFilter(key string, value bool) *Filter {
+	return &Filter{TermBoolFilter: &TermBoolFilter{Key: key, Value: value}}
+}
+
+func NewTermsStringFilter(key string, values ...string) *Filter {
+	return &Filter{TermsStringFilter: &TermsStringFilter{Key: key, Values: values}}
+}
+
+func NewTermsInt64Filter(key string, values ...int64) *Filter {
+	return &Filter{TermsInt64Filter: &TermsInt64Filter{Key: key, Values: values}}
+}
+
+// NewIPRangeFilter returns a filter matching the addresses of a network,
+// ipField has to be set when the field is mapped with the ip type. Only IPv4
+// networks can be matched on the other fields.
+func NewIPRangeFilter(key string, cidr string, ipField bool) (*Filter, error) {
+	if _, _, err := net.ParseCIDR(cidr); err != nil {
+		return nil, err
+	}
+	return &Filter{IPRangeFilter: &IPRangeFilter{Key: key, CIDR: cidr, IPField: ipField}}, nil
+}
+
+// NewGeoDistanceFilter returns a filter matching the points within distance,
+// an elasticsearch distance such as 50km, of the given coordinates
+func NewGeoDistanceFilter(key string, lat, lon float64, distance string) (*Filter, error) {
+	filter := &GeoDistanceFilter{Key: key, Lat: lat, Lon: lon, Distance: distance}
+	if _, err := filter.Meters(); err != nil {
+		return nil, err
+	}
+	return &Filter{GeoDistanceFilter: filter}, nil
+}
+
+// NewDateRangeFilter returns a filter matching the dates between from and to
+// included, both being elasticsearch date math expressions as now-15m or
+// dates in the ISO 8601 format, sent as is to elasticsearch
+func NewDateRangeFilter(key string, from string, to string) (*Filter, error) {
+	if from == "" || to == "" {
+		return nil, fmt.Errorf("both bounds of the %s date range have to be set", key)
+	}
+	for _, bound := range []string{from, to} {
+		if _, err := ParseDateMath(bound, time.Now(), false); err != nil {
+			return nil, err
+		}
+	}
+	return &Filter{DateRangeFilter: &DateRangeFilter{Key: key, From: from, To: to}}, nil
+}
+
+// NewMatchFilter returns a filter matching the words of value, to be used on
+// analyzed text fields where a term filter would require the exact indexed
+// tokens. The operator is either "or", the default, or "and".
+func NewMatchFilter(key string, value string, operator string) (*Filter, error) {
+	switch strings.ToLower(operator) {
+	case "", "or", "and":
+	default:
+		return nil, fmt.Errorf("invalid match operator %s, expected and or or", operator)
+	}
+	return &Filter{MatchFilter: &MatchFilter{Key: key, Value: value, Operator: strings.ToLower(operator)}}, nil
+}
+
+func NewScriptFilter(source string, params map[string]string) *Filter {
+	return &Filter{ScriptFilter: &ScriptFilter{Source: source, Params: params}}
+}
+
+// NewArrayLenFilter returns a filter comparing the number of values of a
+// field to value, for instance the nodes having more than 2 IPV4 addresses.
+// The operator is one of eq, gt, gte, lt and lte. The filter is run as a
+// script, the script filters have to be allowed by the storage.
+func NewArrayLenFilter(key string, operator string, value int64) (*Filter, error) {
+	switch strings.ToLower(operator) {
+	case "eq", "gt", "gte", "lt", "lte":
+	default:
+		return nil, fmt.Errorf("invalid array length operator %s, expected eq, gt, gte, lt or lte", operator)
+	}
+	return &Filter{ArrayLenFilter: &ArrayLenFilter{Key: key, Operator: strings.ToLower(operator), Value: value}}, nil
+}
+
+func NewPrefixFilter(key string, value string) *Filter {
+	return &Filter{PrefixFilter: &PrefixFilter{Key: key, Value: value}}
+}
+
+func NewWildcardFilter(key string, value string) *Filter {
+	return &Filter{WildcardFilter: &WildcardFilter{Key: key, Value: value}}
+}
+
+func NewExistsFilter(key string) *Filter {
+	return &Filter{ExistsFilter: &ExistsFilter{Key: key}}
+}
+
+func NewNotExistsFilter(key string) *Filter {
+	return &Filter{NotExistsFilter: &NotExistsFilter{Key: key}}
+}
+
+func NewNestedFilter(path string, filter *Filter) *Filter {
+	return &Filter{NestedFilter: &NestedFilter{Path: path, Filter: filter}}
+}
+
 func NewFilterForIds(uuids []string, attrs ...string) *Filter {
 	terms := make([]*Filter, len(uuids)*len(attrs))
 	for i, uuid := range uuids {
@@ -573,6 +573,19 @@ func (f *Flow) GetFieldInt64(field string) (int64, error) {
 	return 0, common.ErrFieldNotFound
 }
 
+func (f *Flow) GetFieldFloat64(field string) (float64, error) {
+	i, err := f.GetFieldInt64(field)
+	if err != nil {
+		return 0, err
+	}
+	return float64(i), nil
+}
+
+// GetFieldBool returns ErrFieldNotFound as a flow has no boolean field
+func (f *Flow) GetFieldBool(field string) (bool, error) {
+	return false, common.ErrFieldNotFound
+}
+
 func (f *Flow) GetFields() []interface{} {
 	return fields
 }
@@ -35,6 +35,49 @@ import (
 
 const flowMapping = `
 {
+	"dynamic": "false",
+	"properties": {
+		"UUID": {"type": "string", "index": "not_analyzed"},
+		"LayersPath": {"type": "string", "index": "not_analyzed"},
+		"Application": {"type": "string", "index": "not_analyzed"},
+		"TrackingID": {"type": "string", "index": "not_analyzed"},
+		"L3TrackingID": {"type": "string", "index": "not_analyzed"},
+		"ParentUUID": {"type": "string", "index": "not_analyzed"},
+		"NodeTID": {"type": "string", "index": "not_analyzed"},
+		"ANodeTID": {"type": "string", "index": "not_analyzed"},
+		"BNodeTID": {"type": "string", "index": "not_analyzed"},
+		"Link": {"properties": {
+			"Protocol": {"type": "string", "index": "not_analyzed"},
+			"A": {"type": "string", "index": "not_analyzed"},
+			"B": {"type": "string", "index": "not_analyzed"}
+		}},
+		"Network": {"properties": {
+			"Protocol": {"type": "string", "index": "not_analyzed"},
+			"A": {"type": "string", "index": "not_analyzed"},
+			"B": {"type": "string", "index": "not_analyzed"}
+		}},
+		"Transport": {"properties": {
+			"Protocol": {"type": "string", "index": "not_analyzed"},
+			"A": {"type": "string", "index": "not_analyzed"},
+			"B": {"type": "string", "index": "not_analyzed"}
+		}},
+		"LastUpdateMetric": {"properties": {
+			"Start": {"type": "date", "format": "epoch_second"},
+			"Last": {"type": "date", "format": "epoch_second"},
+			"ABPackets": {"type": "long"},
+			"ABBytes": {"type": "long"},
+			"BAPackets": {"type": "long"},
+			"BABytes": {"type": "long"}
+		}},
+		"Metric": {"properties": {
+			"Start": {"type": "date", "format": "epoch_second"},
+			"Last": {"type": "date", "format": "epoch_second"},
+			"ABPackets": {"type": "long"},
+			"ABBytes": {"type": "long"},
+			"BAPackets": {"type": "long"},
+			"BABytes": {"type": "long"}
+		}}
+	},
 	"dynamic_templates": [
 		{
 			"strings": {
@@ -85,6 +128,15 @@ const metricMapping = `
 	"_parent": {
 		"type": "flow"
 	},
+	"dynamic": "false",
+	"properties": {
+		"Start": {"type": "date", "format": "epoch_second"},
+		"Last": {"type": "date", "format": "epoch_second"},
+		"ABPackets": {"type": "long"},
+		"ABBytes": {"type": "long"},
+		"BAPackets": {"type": "long"},
+		"BABytes": {"type": "long"}
+	},
 	"dynamic_templates": [
 		{
 			"packets": {
@@ -182,12 +234,7 @@ func (c *ElasticSearchStorage) SearchMetrics(fsq filters.SearchQuery, metricFilt
 	}
 
 	flowQuery := c.client.FormatFilter(fsq.Filter, "")
-	musts := []map[string]interface{}{{
-		"has_parent": map[string]interface{}{
-			"type":  "flow",
-			"query": flowQuery,
-		},
-	}}
+	musts := []map[string]interface{}{c.client.HasParentQuery("flow", flowQuery)}
 
 	metricQuery := c.client.FormatFilter(metricFilter, "")
 	musts = append(musts, metricQuery)
@@ -256,14 +303,8 @@ func (c *ElasticSearchStorage) SearchFlows(fsq filters.SearchQuery) (*flow.FlowS
 	}
 
 	flowset := flow.NewFlowSet()
-	if out.Hits.Len() > 0 {
-		for _, d := range out.Hits.Hits {
-			f := new(flow.Flow)
-			if err := json.Unmarshal([]byte(*d.Source), f); err != nil {
-				return nil, err
-			}
-			flowset.Flows = append(flowset.Flows, f)
-		}
+	if err := esclient.UnmarshalHits(out, &flowset.Flows); err != nil {
+		return nil, err
 	}
 
 	if fsq.Dedup {
@@ -276,10 +317,15 @@ func (c *ElasticSearchStorage) SearchFlows(fsq filters.SearchQuery) (*flow.FlowS
 }
 
 func (c *ElasticSearchStorage) Start() {
-	go c.client.Start([]map[string][]byte{
-		{"metric": []byte(metricMapping)},
-		{"flow": []byte(flowMapping)}},
-	)
+	go func() {
+		err := c.client.Start([]map[string][]byte{
+			{"metric": []byte(metricMapping)},
+			{"flow": []byte(flowMapping)}},
+		)
+		if err != nil && err != esclient.ErrStopped {
+			logging.GetLogger().Errorf("Unable to start the Elasticsearch flow storage: %s", err.Error())
+		}
+	}()
 }
 
 func (c *ElasticSearchStorage) Stop() {
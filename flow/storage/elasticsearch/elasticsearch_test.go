@@ -0,0 +1,154 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package elasticsearch
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/skydive-project/skydive/filters"
+	esclient "github.com/skydive-project/skydive/storage/elasticsearch"
+)
+
+// newSingleTypeServer returns a server recording the bodies of the requests
+// by path, acknowledging all of them
+func newSingleTypeServer(t *testing.T) (*httptest.Server, func(path string) map[string]interface{}) {
+	var lock sync.Mutex
+	bodies := make(map[string]map[string]interface{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if data, _ := ioutil.ReadAll(r.Body); len(data) > 0 {
+			var body map[string]interface{}
+			if err := json.Unmarshal(data, &body); err != nil {
+				t.Errorf("Invalid request body %s: %s", string(data), err.Error())
+			}
+
+			lock.Lock()
+			bodies[r.URL.Path] = body
+			lock.Unlock()
+		}
+		w.Write([]byte(`{"status": "green", "hits": {"total": 0, "hits": []}}`))
+	}))
+
+	return server, func(path string) map[string]interface{} {
+		lock.Lock()
+		defer lock.Unlock()
+		return bodies[path]
+	}
+}
+
+func newSingleTypeClient(t *testing.T, server *httptest.Server) *esclient.ElasticSearchClient {
+	client, err := esclient.NewElasticSearchClient([]string{strings.TrimPrefix(server.URL, "http://")}, 10, 60, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetVersion(7); err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.Start([]map[string][]byte{
+		{"metric": []byte(metricMapping)},
+		{"flow": []byte(flowMapping)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestSingleTypeFlowMapping(t *testing.T) {
+	server, body := newSingleTypeServer(t)
+	defer server.Close()
+
+	client := newSingleTypeClient(t, server)
+	defer client.Stop()
+
+	mapping := body("/skydive_v3/_mapping/_doc")
+	if mapping == nil {
+		t.Fatal("Single type mapping not created")
+	}
+
+	var properties struct {
+		Properties struct {
+			UUID    map[string]interface{}
+			DocJoin map[string]interface{}
+			Link    struct {
+				Properties struct {
+					Protocol map[string]interface{}
+				}
+			}
+			Metric struct {
+				Properties struct {
+					Start map[string]interface{}
+				}
+			}
+		} `json:"properties"`
+		Dynamic string `json:"dynamic"`
+	}
+	data, _ := json.Marshal(mapping)
+	if err := json.Unmarshal(data, &properties); err != nil {
+		t.Fatal(err)
+	}
+
+	if properties.Properties.UUID["type"] != "keyword" || properties.Properties.Link.Properties.Protocol["type"] != "keyword" {
+		t.Errorf("Expected the string fields to be mapped as keyword: %s", string(data))
+	}
+	if _, found := properties.Properties.UUID["doc_values"]; found {
+		t.Errorf("The flows are sorted and deduplicated on UUID, doc values have to be kept: %s", string(data))
+	}
+	if properties.Properties.Metric.Properties.Start["type"] != "date" {
+		t.Errorf("Expected the metric start to be mapped as a date: %s", string(data))
+	}
+	if properties.Dynamic != "false" {
+		t.Errorf("Expected the unknown fields not to be indexed: %s", string(data))
+	}
+
+	relations := map[string]interface{}{"flow": []interface{}{"metric"}}
+	if !reflect.DeepEqual(properties.Properties.DocJoin["relations"], relations) {
+		t.Errorf("Expected the metrics to be joined to the flows: %s", string(data))
+	}
+}
+
+func TestSingleTypeSearchMetrics(t *testing.T) {
+	server, body := newSingleTypeServer(t)
+	defer server.Close()
+
+	client := newSingleTypeClient(t, server)
+	defer client.Stop()
+
+	storage := &ElasticSearchStorage{client: client}
+	fsq := filters.SearchQuery{Filter: filters.NewTermStringFilter("UUID", "flow-1")}
+	if _, err := storage.SearchMetrics(fsq, filters.NewGtInt64Filter("Start", 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	request, _ := json.Marshal(body("/skydive/_doc/_search"))
+	if !strings.Contains(string(request), `"has_parent":{"parent_type":"flow"`) {
+		t.Errorf("Expected a has_parent query on the flow parent type: %s", string(request))
+	}
+}
@@ -76,6 +76,7 @@ source openrc admin admin
 export PATH=$PATH:/opt/go/bin:/opt/stack/go/bin:/opt/stack/protoc/bin
 export GOROOT=/opt/go
 export GOPATH=/opt/stack/go
-export GO_VERSION=1.6
+export GO_VERSION=1.17
+export GO111MODULE=off
 cd /opt/stack/go/src/github.com/skydive-project/skydive/
 SKYDIVE_ANALYZERS=localhost:8082 make test.functionals TAGS="neutron" VERBOSE=true TIMEOUT=2m TEST_PATTERN=Neutron
@@ -0,0 +1,198 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	elastigo "github.com/lebauce/elastigo/lib"
+
+	"github.com/skydive-project/skydive/logging"
+)
+
+// ErrCircuitOpen is reported for the bulk requests not sent while the
+// cluster is rejecting the indexing requests
+var ErrCircuitOpen = errors.New("elasticsearch : circuit breaker open, bulk request dropped")
+
+// BreakerState is the state of the circuit breaker of the bulk requests
+type BreakerState int
+
+const (
+	// BreakerClosed lets all the bulk requests through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen drops the bulk requests until the cooldown is over
+	BreakerOpen
+	// BreakerHalfOpen lets a single bulk request through to test whether
+	// the cluster recovered
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker opens after threshold rejections within the window, shedding
+// the bulk requests for the cooldown period to let the cluster recover
+type circuitBreaker struct {
+	sync.Mutex
+	threshold  int
+	window     time.Duration
+	cooldown   time.Duration
+	state      BreakerState
+	rejections []time.Time
+	openedAt   time.Time
+	trial      bool
+}
+
+// allow returns whether a bulk request can be sent
+func (b *circuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		fallthrough
+	case BreakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+	}
+	return true
+}
+
+// done records the outcome of a bulk request
+func (b *circuitBreaker) done(rejected bool, failed bool) {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.trial = false
+
+	now := time.Now()
+	switch {
+	case rejected && b.state == BreakerHalfOpen:
+		b.open(now)
+	case rejected:
+		kept := b.rejections[:0]
+		for _, t := range b.rejections {
+			if now.Sub(t) < b.window {
+				kept = append(kept, t)
+			}
+		}
+		b.rejections = append(kept, now)
+
+		if len(b.rejections) >= b.threshold {
+			b.open(now)
+		}
+	case !failed && b.state == BreakerHalfOpen:
+		logging.GetLogger().Infof("Elasticsearch accepts the bulk requests again, closing the circuit breaker")
+		b.state = BreakerClosed
+		b.rejections = nil
+	}
+}
+
+func (b *circuitBreaker) open(now time.Time) {
+	logging.GetLogger().Errorf("Elasticsearch rejects the bulk requests, dropping them for %s", b.cooldown)
+	b.state = BreakerOpen
+	b.openedAt = now
+	b.rejections = nil
+}
+
+// SetCircuitBreaker makes the bulk requests be dropped for cooldown after
+// threshold of them were rejected by the cluster within window, a threshold
+// of 0 disabling the circuit breaker
+func (c *ElasticSearchClient) SetCircuitBreaker(threshold int, window time.Duration, cooldown time.Duration) {
+	if threshold <= 0 {
+		c.breaker = nil
+		return
+	}
+	c.breaker = &circuitBreaker{threshold: threshold, window: window, cooldown: cooldown}
+}
+
+// BreakerState returns the state of the circuit breaker of the bulk requests
+func (c *ElasticSearchClient) BreakerState() BreakerState {
+	if c.breaker == nil {
+		return BreakerClosed
+	}
+
+	c.breaker.Lock()
+	defer c.breaker.Unlock()
+
+	// an open breaker is half-open once the cooldown is over
+	if c.breaker.state == BreakerOpen && time.Since(c.breaker.openedAt) >= c.breaker.cooldown {
+		return BreakerHalfOpen
+	}
+	return c.breaker.state
+}
+
+// rejectedError returns whether the cluster rejected a request as its
+// queues are full
+func rejectedError(err error) bool {
+	var esErr elastigo.ESError
+	if errors.As(err, &esErr) {
+		return esErr.Code == 429 || strings.Contains(esErr.What, "es_rejected_execution_exception")
+	}
+	return false
+}
+
+// rejectedItems returns whether some of the items of a bulk response were
+// rejected as the cluster queues are full
+func rejectedItems(items []map[string]interface{}) bool {
+	for _, item := range items {
+		for _, action := range item {
+			result, ok := action.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if status, _ := result["status"].(float64); status == 429 {
+				return true
+			}
+			if e, ok := result["error"].(map[string]interface{}); ok && e["type"] == "es_rejected_execution_exception" {
+				return true
+			}
+		}
+	}
+	return false
+}
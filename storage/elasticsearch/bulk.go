@@ -0,0 +1,666 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	elastigo "github.com/lebauce/elastigo/lib"
+
+	"github.com/skydive-project/skydive/logging"
+)
+
+func (c *ElasticSearchClient) sendBulk(buf *bytes.Buffer) error {
+	return c.sendBulkAttempt(buf.Bytes(), 0)
+}
+
+// sendBulkAttempt sends a bulk request, the items rejected because of an
+// overloaded cluster being sent again later on unless they were already
+// retried bulkMaxRetries times
+func (c *ElasticSearchClient) sendBulkAttempt(body []byte, attempt int) error {
+	var response struct {
+		Errors bool                     `json:"errors"`
+		Items  []map[string]interface{} `json:"items"`
+	}
+
+	if !c.breaker.allow() {
+		c.bulkErrLock.Lock()
+		c.bulkErr = ErrCircuitOpen
+		c.bulkErrLock.Unlock()
+		return ErrCircuitOpen
+	}
+
+	atomic.AddInt32(&c.bulkInFlight, 1)
+	defer atomic.AddInt32(&c.bulkInFlight, -1)
+
+	start := time.Now()
+	err := c.jsonRequest("POST", "/_bulk", "", string(body), &response)
+	rejected := rejectedError(err)
+	if err == nil && response.Errors {
+		rejected = rejectedItems(response.Items)
+
+		retried := 0
+		if attempt < c.bulkMaxRetries {
+			var retry []byte
+			if retry, retried = retriableItems(body, response.Items); retried > 0 {
+				c.retryBulk(retry, retried, attempt)
+			}
+		}
+		if failed := failedItems(response.Items) - retried; failed > 0 || retried == 0 {
+			err = fmt.Errorf("Bulk insertion error, failed item count %d", failed)
+		}
+	}
+	c.breaker.done(rejected, err != nil)
+	c.metrics.OnBulk(time.Since(start), err)
+
+	if err != nil {
+		c.bulkErrLock.Lock()
+		c.bulkErr = err
+		c.bulkErrLock.Unlock()
+	} else {
+		atomic.StoreInt32(&c.bulkFailures, 0)
+	}
+
+	return err
+}
+
+// failedItems returns the number of items of a bulk response that hold an
+// error or a status code other than a success
+func failedItems(items []map[string]interface{}) int {
+	failed := 0
+	for _, item := range items {
+		for _, action := range item {
+			result, ok := action.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if status, _ := result["status"].(float64); result["error"] != nil || status >= 300 {
+				failed++
+			}
+		}
+	}
+	return failed
+}
+
+// retriableItems returns the actions of a bulk request matching the items of
+// the response rejected with a 429 or 503 status, along with their count
+func retriableItems(body []byte, items []map[string]interface{}) ([]byte, int) {
+	var retry bytes.Buffer
+	count := 0
+
+	lines := bytes.Split(bytes.TrimRight(body, "\n"), []byte("\n"))
+	for i, line := 0, 0; i < len(items) && line < len(lines); i++ {
+		var action map[string]json.RawMessage
+		json.Unmarshal(lines[line], &action)
+
+		// all the actions but the deletions are followed by a source
+		end := line + 1
+		if _, ok := action["delete"]; !ok {
+			end++
+		}
+		if end > len(lines) {
+			break
+		}
+
+		for _, result := range items[i] {
+			result, _ := result.(map[string]interface{})
+			if status, _ := result["status"].(float64); status == 429 || status == 503 {
+				for _, l := range lines[line:end] {
+					retry.Write(l)
+					retry.WriteByte('\n')
+				}
+				count++
+			}
+		}
+		line = end
+	}
+
+	return retry.Bytes(), count
+}
+
+// retryBulk sends the given actions again once the backoff delay of the
+// attempt elapsed, the pending retries being waited for by Flush
+func (c *ElasticSearchClient) retryBulk(body []byte, count int, attempt int) {
+	c.retryLock.Lock()
+	c.retrying += count
+	c.retryLock.Unlock()
+
+	go func() {
+		defer func() {
+			c.retryLock.Lock()
+			c.retrying -= count
+			c.retryCond.Broadcast()
+			c.retryLock.Unlock()
+		}()
+
+		select {
+		case <-time.After(c.bulkRetryDelay << uint(attempt)):
+		case <-c.quit:
+			logging.GetLogger().Errorf("Bulk indexing error: %d documents dropped on stop before being retried", count)
+			return
+		}
+
+		err := c.sendBulkAttempt(body, attempt+1)
+
+		// the requests dropped by the circuit breaker never reached the
+		// cluster and are delayed further
+		if err == ErrCircuitOpen && attempt+1 < c.bulkMaxRetries {
+			c.retryBulk(body, count, attempt+1)
+			return
+		}
+
+		if err != nil {
+			logging.GetLogger().Errorf("Bulk indexing error after %d retries: %s", attempt+1, err.Error())
+
+			select {
+			case c.bulkErrors <- err:
+			default:
+			}
+		}
+	}()
+}
+
+// waitRetries waits for the rejected documents to be retried
+func (c *ElasticSearchClient) waitRetries() {
+	c.retryLock.Lock()
+	defer c.retryLock.Unlock()
+
+	for c.retrying > 0 {
+		c.retryCond.Wait()
+	}
+}
+
+// bulkIndexer returns the current bulk indexer, it is replaced when the
+// connection to the cluster is lost
+func (c *ElasticSearchClient) bulkIndexer() *elastigo.BulkIndexer {
+	c.indexerLock.RLock()
+	defer c.indexerLock.RUnlock()
+	return c.indexer
+}
+
+// PendingDocs returns the number of documents buffered by the bulk indexer
+// and not yet sent, the ones waiting in the queue of pending documents or
+// to be retried included
+func (c *ElasticSearchClient) PendingDocs() int {
+	pending := c.bulkIndexer().PendingDocuments()
+	if c.pending != nil {
+		pending += c.pending.len()
+	}
+
+	c.retryLock.Lock()
+	pending += c.retrying
+	c.retryLock.Unlock()
+
+	return pending
+}
+
+// QueueDepth returns the number of bulk requests being sent and not yet
+// acknowledged by the cluster
+func (c *ElasticSearchClient) QueueDepth() int {
+	return int(atomic.LoadInt32(&c.bulkInFlight))
+}
+
+// SetBulkMaxFailures sets the number of consecutive bulk failures after which
+// the bulk indexer is recreated once the cluster is back, 0 to never
+// recreate it
+func (c *ElasticSearchClient) SetBulkMaxFailures(maxFailures int) {
+	c.bulkMaxFailures = maxFailures
+}
+
+// SetBulkRetry sets the number of times the documents rejected by an
+// overloaded cluster are sent again, the delay before each retry doubling
+// from the given one, 0 retries dropping the rejected documents
+func (c *ElasticSearchClient) SetBulkRetry(maxRetries int, delay time.Duration) {
+	c.bulkMaxRetries = maxRetries
+	c.bulkRetryDelay = delay
+}
+
+// SetDrainTimeout sets the maximum time Stop waits for the buffered documents
+// to be sent, 0 meaning waiting as long as needed
+func (c *ElasticSearchClient) SetDrainTimeout(timeout time.Duration) {
+	c.drainTimeout = timeout
+}
+
+// Flush sends the documents pending in the bulk indexer and waits for them
+// to be sent, it returns the last error that occurred meanwhile
+func (c *ElasticSearchClient) Flush() error {
+	if !c.Started() {
+		return ErrNotStarted
+	}
+	if c.pending != nil {
+		c.pending.wait()
+	}
+	return c.flushIndexer()
+}
+
+// drain flushes the documents buffered on stop, it returns false if they
+// were not sent within the drain timeout
+func (c *ElasticSearchClient) drain() bool {
+	pending := c.PendingDocs()
+	if pending == 0 {
+		return true
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		if c.pending != nil {
+			c.pending.wait()
+		}
+		done <- c.flushIndexer()
+	}()
+
+	var timeout <-chan time.Time
+	if c.drainTimeout > 0 {
+		timeout = time.After(c.drainTimeout)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			logging.GetLogger().Errorf("Flushed 0 documents on stop, %d dropped: %s", pending, err.Error())
+		} else {
+			logging.GetLogger().Infof("Flushed %d documents on stop, 0 dropped", pending)
+		}
+		return true
+	case <-timeout:
+		logging.GetLogger().Errorf("Flushed 0 documents on stop, %d dropped: not sent within %s", pending, c.drainTimeout)
+		return false
+	}
+}
+
+func (c *ElasticSearchClient) flushIndexer() error {
+	c.bulkErrLock.Lock()
+	c.bulkErr = nil
+	c.bulkErrLock.Unlock()
+
+	c.bulkIndexer().Flush()
+	c.waitRetries()
+
+	c.bulkErrLock.Lock()
+	defer c.bulkErrLock.Unlock()
+	return c.bulkErr
+}
+
+func (c *ElasticSearchClient) startIndexer() {
+	c.quit = make(chan struct{})
+	c.indexer.Start()
+
+	// the consumer may be blocked by a stalled cluster on stop, it is not
+	// waited for
+	if c.maxPending > 0 {
+		c.pending = newPendingQueue(c.maxPending, c.pendingPolicy)
+		go c.consumePending(c.pending)
+	}
+
+	c.wg.Add(1)
+	go c.forwardBulkErrors()
+
+	c.started.Store(true)
+}
+
+// forwardBulkErrors logs the errors reported by the bulk indexer and forwards
+// them to the Errors channel until the client is stopped
+func (c *ElasticSearchClient) forwardBulkErrors() {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case errBuf := <-c.bulkIndexer().ErrorChannel:
+			logging.GetLogger().Errorf("Bulk indexing error: %s", errBuf.Err.Error())
+
+			select {
+			case c.bulkErrors <- errBuf.Err:
+			default:
+			}
+
+			// the cluster is reachable but overloaded when the requests
+			// are dropped by the circuit breaker
+			if errBuf.Err == ErrCircuitOpen {
+				continue
+			}
+
+			failures := atomic.AddInt32(&c.bulkFailures, 1)
+			if c.bulkMaxFailures > 0 && int(failures) >= c.bulkMaxFailures {
+				c.reconnect()
+			}
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// reconnect waits for the cluster to be reachable and replaces the bulk
+// indexer by a new one
+func (c *ElasticSearchClient) reconnect() {
+	logging.GetLogger().Errorf("%d consecutive bulk failures, waiting for Elasticsearch to be back", atomic.LoadInt32(&c.bulkFailures))
+
+	for {
+		err := c.HealthCheck()
+		if err == nil {
+			break
+		}
+		logging.GetLogger().Debugf("Elasticsearch still unreachable: %s", err.Error())
+
+		select {
+		case <-time.After(healthPollInterval):
+		case <-c.quit:
+			return
+		}
+	}
+
+	c.indexerLock.Lock()
+	if c.stopping.Load() == true {
+		c.indexerLock.Unlock()
+		return
+	}
+
+	old := c.indexer
+	c.indexer = c.connection.NewBulkIndexerErrors(c.bulkMaxConns, old.RetryForSeconds)
+	c.indexer.BulkMaxDocs = old.BulkMaxDocs
+	c.indexer.BulkMaxBuffer = old.BulkMaxBuffer
+	c.indexer.BufferDelayMax = old.BufferDelayMax
+	c.indexer.Sender = c.sendBulk
+	c.indexer.Start()
+	c.indexerLock.Unlock()
+
+	c.hosts.reset()
+	atomic.StoreInt32(&c.bulkFailures, 0)
+
+	// the errors of the previous indexer have to be drained for its
+	// stop not to block
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case errBuf := <-old.ErrorChannel:
+				logging.GetLogger().Errorf("Bulk indexing error: %s", errBuf.Err.Error())
+			case <-done:
+				return
+			}
+		}
+	}()
+	old.Stop()
+	close(done)
+
+	logging.GetLogger().Infof("Elasticsearch bulk indexer reconnected")
+}
+
+// Errors returns the channel on which the bulk indexing errors are sent,
+// errors are dropped when the channel is full
+func (c *ElasticSearchClient) Errors() <-chan error {
+	return c.bulkErrors
+}
+
+// IndexBulk enqueues the given documents, indexed by id, in the bulk indexer.
+// The documents that could not be enqueued are returned with the reason of
+// the rejection, errors occurring once the documents are sent are reported
+// through the Errors channel.
+func (c *ElasticSearchClient) IndexBulk(obj string, docs map[string]interface{}) (map[string]error, error) {
+	return c.IndexBulkWithOptions(obj, docs, WriteOptions{})
+}
+
+// IndexBulkWithOptions enqueues the given documents as IndexBulk does, only
+// the ingest pipeline of the options applying to bulk writes. The bulk
+// indexer having no notion of pipeline, the documents indexed through one
+// are sent right away in their own bulk request, whose failure is returned.
+func (c *ElasticSearchClient) IndexBulkWithOptions(obj string, docs map[string]interface{}, opts WriteOptions) (map[string]error, error) {
+	if opts.Refresh != "" || opts.Version > 0 || opts.IfPrimaryTerm > 0 {
+		return nil, fmt.Errorf("%w: only the pipeline applies to bulk writes", ErrIncompatibleOptions)
+	}
+	if !c.Started() {
+		return nil, ErrNotStarted
+	}
+
+	if opts.Pipeline != "" {
+		return c.indexPipelineBulk(obj, docs, opts.Pipeline)
+	}
+
+	rejected := make(map[string]error)
+	for id, data := range docs {
+		body, err := c.documentBody(obj, "", data)
+		if err == nil {
+			index, docType, id := c.currentIndex(obj), c.docType(obj), id
+			err = c.enqueue(func(indexer *elastigo.BulkIndexer) error {
+				return indexer.Index(index, docType, id, "", "", nil, json.RawMessage(body))
+			})
+		}
+		if err != nil {
+			rejected[id] = err
+		}
+	}
+
+	return rejected, nil
+}
+
+// indexPipelineBulk sends the documents in a bulk request whose actions name
+// the ingest pipeline
+func (c *ElasticSearchClient) indexPipelineBulk(obj string, docs map[string]interface{}, pipeline string) (map[string]error, error) {
+	rejected := make(map[string]error)
+	index, docType := c.currentIndex(obj), c.docType(obj)
+
+	var buf bytes.Buffer
+	for id, data := range docs {
+		body, err := c.documentBody(obj, "", data)
+		if err != nil {
+			rejected[id] = err
+			continue
+		}
+
+		action, err := json.Marshal(map[string]interface{}{
+			"index": map[string]string{"_index": index, "_type": docType, "_id": id, "pipeline": pipeline},
+		})
+		if err != nil {
+			rejected[id] = err
+			continue
+		}
+
+		buf.Write(action)
+		buf.WriteByte('\n')
+		buf.Write(body)
+		buf.WriteByte('\n')
+	}
+
+	if buf.Len() == 0 {
+		return rejected, nil
+	}
+
+	// the documents buffered before are sent first for the writes of a same
+	// document to be applied in order
+	c.bulkIndexer().Flush()
+
+	return rejected, c.sendBulkAttempt(buf.Bytes(), 0)
+}
+
+// BulkUpdate enqueues partial updates of documents, indexed by id, in the
+// bulk indexer. As for IndexBulk, the updates that couldn't be enqueued are
+// returned, the bulk errors being reported on the Errors channel.
+func (c *ElasticSearchClient) BulkUpdate(obj string, updates map[string]interface{}) (map[string]error, error) {
+	if !c.Started() {
+		return nil, ErrNotStarted
+	}
+
+	rejected := make(map[string]error)
+
+	// the updates have to target the index holding each document, an
+	// alias spanning several indices can't be written to
+	indices := make(map[string]string)
+	if c.rolling {
+		ids := make([]string, 0, len(updates))
+		for id := range updates {
+			ids = append(ids, id)
+		}
+
+		docs, err := c.multiGetSearch(obj, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			if !doc.Found {
+				rejected[doc.Id] = ErrNotFound
+				continue
+			}
+			indices[doc.Id] = doc.Index
+		}
+	}
+
+	for id, data := range updates {
+		if _, found := rejected[id]; found {
+			continue
+		}
+
+		index := c.typeAlias(obj)
+		if c.rolling {
+			index = indices[id]
+		}
+
+		body, err := json.Marshal(data)
+		if err == nil {
+			docType, id := c.docType(obj), id
+			err = c.enqueue(func(indexer *elastigo.BulkIndexer) error {
+				return indexer.UpdateWithPartialDoc(index, docType, id, "", nil, json.RawMessage(body), false)
+			})
+		}
+		if err != nil {
+			rejected[id] = err
+		}
+	}
+
+	return rejected, nil
+}
+
+// BulkItemError describes a document rejected by a bulk import
+type BulkItemError struct {
+	ID     string
+	Status int
+	Type   string
+	Reason string
+}
+
+// BulkImport synchronously indexes the given documents, indexed by id, in
+// a single bulk request and returns the documents that were rejected
+func (c *ElasticSearchClient) BulkImport(obj string, docs map[string]interface{}) ([]BulkItemError, error) {
+	var response struct {
+		Items []map[string]struct {
+			ID     string          `json:"_id"`
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+
+	var failures []BulkItemError
+
+	ids := make([]string, 0, len(docs))
+	for id := range docs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var buf bytes.Buffer
+	for _, id := range ids {
+		body, err := c.documentBody(obj, "", docs[id])
+		if err != nil {
+			failures = append(failures, BulkItemError{ID: id, Reason: err.Error()})
+			continue
+		}
+
+		action, _ := json.Marshal(map[string]interface{}{
+			"index": map[string]string{"_index": c.currentIndex(obj), "_type": c.docType(obj), "_id": id},
+		})
+		buf.Write(action)
+		buf.WriteByte('\n')
+		buf.Write(body)
+		buf.WriteByte('\n')
+	}
+
+	if buf.Len() == 0 {
+		return failures, nil
+	}
+
+	start := time.Now()
+	err := c.jsonRequest("POST", "/_bulk", "", buf.String(), &response)
+	c.metrics.OnBulk(time.Since(start), err)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range response.Items {
+		for _, result := range item {
+			if result.Error == nil {
+				continue
+			}
+
+			failure := BulkItemError{ID: result.ID, Status: result.Status}
+
+			// versions prior to 5 return the error as a string
+			var reason struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			}
+			if json.Unmarshal(result.Error, &reason) == nil {
+				failure.Type, failure.Reason = reason.Type, reason.Reason
+			} else {
+				json.Unmarshal(result.Error, &failure.Reason)
+			}
+
+			failures = append(failures, failure)
+		}
+	}
+
+	return failures, nil
+}
+
+// BulkDelete enqueues the deletion of the given documents in the bulk indexer
+func (c *ElasticSearchClient) BulkDelete(obj string, ids []string) error {
+	if !c.Started() {
+		return ErrNotStarted
+	}
+
+	// the index holding each document is unknown with daily indices
+	if c.rolling {
+		body, _ := json.Marshal(map[string]interface{}{
+			"query": map[string]interface{}{
+				"ids": map[string][]string{"values": ids},
+			},
+		})
+		_, err := c.DeleteByQuery(obj, string(body))
+		return err
+	}
+
+	index, docType := c.typeAlias(obj), c.docType(obj)
+	for _, id := range ids {
+		id := id
+		err := c.enqueue(func(indexer *elastigo.BulkIndexer) error {
+			indexer.Delete(index, docType, id)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
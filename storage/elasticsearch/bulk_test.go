@@ -0,0 +1,805 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	elastigo "github.com/lebauce/elastigo/lib"
+)
+
+type bulkServer struct {
+	sync.Mutex
+	*httptest.Server
+	failing   bool
+	response  string
+	responses []string
+	batches   [][]string
+}
+
+func (b *bulkServer) lines() (lines []string) {
+	b.Lock()
+	defer b.Unlock()
+
+	for _, batch := range b.batches {
+		lines = append(lines, batch...)
+	}
+	return
+}
+
+func (b *bulkServer) waitLines(t *testing.T, count int) []string {
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(10 * time.Millisecond) {
+		if lines := b.lines(); len(lines) >= count {
+			return lines
+		}
+	}
+	t.Fatalf("Expected %d bulk lines, got %d", count, len(b.lines()))
+	return nil
+}
+
+func newBulkServer() *bulkServer {
+	b := &bulkServer{}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_bulk" {
+			var batch []string
+			scanner := bufio.NewScanner(r.Body)
+			for scanner.Scan() {
+				batch = append(batch, scanner.Text())
+			}
+
+			b.Lock()
+			defer b.Unlock()
+
+			b.batches = append(b.batches, batch)
+			if len(b.responses) > 0 {
+				w.Write([]byte(b.responses[0]))
+				b.responses = b.responses[1:]
+				return
+			}
+			if b.failing {
+				w.Write([]byte(`{"errors": true, "items": [{}]}`))
+				return
+			}
+			if b.response != "" {
+				w.Write([]byte(b.response))
+				return
+			}
+		}
+		w.Write([]byte(`{"errors": false, "items": []}`))
+	}))
+	return b
+}
+
+func newStartedTestClient(t *testing.T, server *bulkServer) *ElasticSearchClient {
+	client := newTestClient(t, hostOf(server.Server))
+	client.startIndexer()
+	return client
+}
+
+func TestBulkDelete(t *testing.T) {
+	server := newBulkServer()
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+	if err := client.BulkDelete("flow", []string{"aaa"}); err != ErrNotStarted {
+		t.Errorf("Expected ErrNotStarted, got: %v", err)
+	}
+
+	client = newStartedTestClient(t, server)
+	defer client.Stop()
+
+	ids := make([]string, 2500)
+	for i := range ids {
+		ids[i] = strings.Repeat("a", i%10+1)
+	}
+
+	if err := client.BulkDelete("flow", ids); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := server.waitLines(t, 2500)
+	for _, line := range lines {
+		if !strings.HasPrefix(line, `{"delete":`) {
+			t.Fatalf("Expected a delete action, got: %s", line)
+		}
+	}
+
+	server.Lock()
+	defer server.Unlock()
+	if len(server.batches) != 25 {
+		t.Errorf("Expected 25 bulk batches, got %d", len(server.batches))
+	}
+}
+
+func TestBulkSettings(t *testing.T) {
+	client, err := NewElasticSearchClient([]string{"127.0.0.1:9200"}, 10, 60, 500, 1024, 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.indexer.BulkMaxDocs != 500 {
+		t.Errorf("Expected BulkMaxDocs to be 500, got %d", client.indexer.BulkMaxDocs)
+	}
+	if client.indexer.BulkMaxBuffer != 1024 {
+		t.Errorf("Expected BulkMaxBuffer to be 1024, got %d", client.indexer.BulkMaxBuffer)
+	}
+	if client.indexer.BufferDelayMax != 200*time.Millisecond {
+		t.Errorf("Expected BufferDelayMax to be 200ms, got %s", client.indexer.BufferDelayMax)
+	}
+
+	client, err = NewElasticSearchClient([]string{"127.0.0.1:9200"}, 10, 60, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.indexer.BulkMaxDocs != elastigo.BulkMaxDocs {
+		t.Errorf("Expected default BulkMaxDocs %d, got %d", elastigo.BulkMaxDocs, client.indexer.BulkMaxDocs)
+	}
+	if client.indexer.BulkMaxBuffer != elastigo.BulkMaxBuffer {
+		t.Errorf("Expected default BulkMaxBuffer %d, got %d", elastigo.BulkMaxBuffer, client.indexer.BulkMaxBuffer)
+	}
+	if client.indexer.BufferDelayMax != time.Duration(elastigo.BulkDelaySeconds)*time.Second {
+		t.Errorf("Expected default BufferDelayMax, got %s", client.indexer.BufferDelayMax)
+	}
+}
+
+func TestBulkMaxBytes(t *testing.T) {
+	server := newBulkServer()
+	defer server.Close()
+
+	// only the size in bytes can trigger a bulk request
+	client, err := NewElasticSearchClient([]string{hostOf(server.Server)}, 10, 60, 1000, 2048, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.startIndexer()
+	defer client.Stop()
+
+	for i := 0; i < 5; i++ {
+		if _, err := client.IndexBulk("flow", map[string]interface{}{fmt.Sprintf("small-%d", i): map[string]interface{}{"UUID": i}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if lines := server.lines(); len(lines) != 0 {
+		t.Fatalf("Small documents should stay buffered, got %d bulk lines", len(lines))
+	}
+
+	if _, err := client.IndexBulk("flow", map[string]interface{}{"large": map[string]interface{}{"Payload": strings.Repeat("a", 4096)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the large document crosses the size threshold and flushes all of them
+	lines := server.waitLines(t, 12)
+	if !strings.Contains(lines[11], strings.Repeat("a", 4096)) {
+		t.Errorf("Expected the large document to be sent last, got: %s", lines[11])
+	}
+
+	server.Lock()
+	defer server.Unlock()
+	if len(server.batches) != 1 {
+		t.Errorf("Expected a single bulk batch, got %d", len(server.batches))
+	}
+}
+
+func TestStopDrain(t *testing.T) {
+	server := newBulkServer()
+	defer server.Close()
+
+	client, err := NewElasticSearchClient([]string{hostOf(server.Server)}, 10, 60, 1000, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.startIndexer()
+
+	docs := make(map[string]interface{})
+	for i := 0; i < 10; i++ {
+		docs[fmt.Sprintf("flow-%d", i)] = map[string]interface{}{"UUID": i}
+	}
+	if _, err := client.IndexBulk("flow", docs); err != nil {
+		t.Fatal(err)
+	}
+	if pending := client.PendingDocs(); pending != 10 {
+		t.Fatalf("Expected 10 pending documents, got %d", pending)
+	}
+
+	client.Stop()
+
+	// no waiting, the documents have to be sent when Stop returns
+	if lines := server.lines(); len(lines) != 20 {
+		t.Errorf("Expected the 10 documents to be sent on stop, got %d bulk lines", len(lines))
+	}
+}
+
+func TestStopDrainTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	client := newStartedTestClient(t, &bulkServer{Server: server})
+	client.SetDrainTimeout(100 * time.Millisecond)
+
+	if _, err := client.IndexBulk("flow", map[string]interface{}{"flow-1": map[string]interface{}{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	client.Stop()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Stop should give up after the drain timeout, took %s", elapsed)
+	}
+}
+
+// newStalledTestClient returns a client sending each document in its own
+// bulk request to a server not answering until release is closed
+func newStalledTestClient(t *testing.T, capacity int, policy string) (*ElasticSearchClient, chan struct{}, func()) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"errors": false, "items": []}`))
+	}))
+
+	client := newTestClient(t, hostOf(server))
+	if err := client.SetMaxPending(capacity, policy); err != nil {
+		t.Fatal(err)
+	}
+	client.indexer.BulkMaxDocs = 1
+	client.startIndexer()
+
+	// wait for the first document to be stuck in the indexer
+	if _, err := client.IndexBulk("flow", map[string]interface{}{"flow-0": map[string]interface{}{}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; client.pending.len() != 0; i++ {
+		if i == 100 {
+			t.Fatal("The pending document was not consumed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return client, release, server.Close
+}
+
+func TestMaxPendingDropOldest(t *testing.T) {
+	client, release, closeServer := newStalledTestClient(t, 2, PendingDropOldest)
+	defer closeServer()
+
+	for i := 1; i <= 4; i++ {
+		id := fmt.Sprintf("flow-%d", i)
+		if _, err := client.IndexBulk("flow", map[string]interface{}{id: map[string]interface{}{}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if pending := client.pending.len(); pending != 2 {
+		t.Errorf("Expected the queue to be bounded to 2 documents, got %d", pending)
+	}
+	if dropped := client.DroppedDocs(); dropped != 2 {
+		t.Errorf("Expected 2 dropped documents, got %d", dropped)
+	}
+
+	close(release)
+	client.Stop()
+}
+
+func TestMaxPendingBlock(t *testing.T) {
+	client, release, closeServer := newStalledTestClient(t, 1, PendingBlock)
+	defer closeServer()
+
+	if _, err := client.IndexBulk("flow", map[string]interface{}{"flow-1": map[string]interface{}{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.IndexBulk("flow", map[string]interface{}{"flow-2": map[string]interface{}{}})
+		done <- err
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Expected the caller to be blocked by a full queue")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The caller was not released once the cluster answered")
+	}
+
+	if dropped := client.DroppedDocs(); dropped != 0 {
+		t.Errorf("No document should be dropped, got %d", dropped)
+	}
+	client.Stop()
+
+	if err := client.SetMaxPending(10, "drop_newest"); err == nil {
+		t.Error("Expected an error for an invalid policy")
+	}
+}
+
+func TestBulkUpdate(t *testing.T) {
+	server := newBulkServer()
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+	if _, err := client.BulkUpdate("node", nil); err != ErrNotStarted {
+		t.Errorf("Expected ErrNotStarted, got: %v", err)
+	}
+
+	client = newStartedTestClient(t, server)
+	defer client.Stop()
+
+	updates := make(map[string]interface{})
+	for i := 0; i < 5; i++ {
+		updates[fmt.Sprintf("node-%d", i)] = map[string]interface{}{"Degree": i}
+	}
+	updates["invalid"] = func() {}
+
+	rejected, err := client.BulkUpdate("node", updates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rejected) != 1 || rejected["invalid"] == nil {
+		t.Errorf("Expected only the invalid update to be rejected, got: %v", rejected)
+	}
+
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := server.lines()
+	if len(lines) != 10 {
+		t.Fatalf("Expected 10 bulk lines, got %d", len(lines))
+	}
+	for i := 0; i < len(lines); i += 2 {
+		var action map[string]map[string]string
+		if err := json.Unmarshal([]byte(lines[i]), &action); err != nil {
+			t.Fatal(err)
+		}
+		if update := action["update"]; update == nil || update["_index"] != "skydive" || update["_type"] != "node" {
+			t.Errorf("Expected an update action, got: %s", lines[i])
+		}
+
+		var doc map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[i+1]), &doc); err != nil {
+			t.Fatal(err)
+		}
+		if _, found := doc["doc"]; !found {
+			t.Errorf("Expected a partial document update, got: %s", lines[i+1])
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	server := newBulkServer()
+	defer server.Close()
+	server.response = `{"errors": true, "items": [{"index": {"status": 429, "error": {"type": "es_rejected_execution_exception"}}}]}`
+
+	// the rejected documents are not retried for each request to count as
+	// a single rejection
+	client := newTestClient(t, hostOf(server.Server))
+	client.SetBulkRetry(0, 0)
+	client.startIndexer()
+	defer client.Stop()
+	client.SetCircuitBreaker(3, time.Minute, 200*time.Millisecond)
+
+	send := func() {
+		if _, err := client.IndexBulk("flow", map[string]interface{}{"flow-1": map[string]interface{}{}}); err != nil {
+			t.Fatal(err)
+		}
+		client.Flush()
+	}
+
+	for i := 0; i < 3; i++ {
+		if state := client.BreakerState(); state != BreakerClosed {
+			t.Fatalf("Expected a closed breaker after %d rejections, got %s", i, state)
+		}
+		send()
+	}
+	if state := client.BreakerState(); state != BreakerOpen {
+		t.Fatalf("Expected an open breaker after 3 rejections, got %s", state)
+	}
+
+	// the requests are dropped while open
+	if _, err := client.IndexBulk("flow", map[string]interface{}{"flow-1": map[string]interface{}{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Flush(); err != ErrCircuitOpen {
+		t.Errorf("Expected ErrCircuitOpen, got %v", err)
+	}
+	server.Lock()
+	if len(server.batches) != 3 {
+		t.Errorf("Expected the request to be dropped, got %d bulk requests", len(server.batches))
+	}
+	server.response = ""
+	server.Unlock()
+
+	time.Sleep(200 * time.Millisecond)
+	if state := client.BreakerState(); state != BreakerHalfOpen {
+		t.Fatalf("Expected a half-open breaker after the cooldown, got %s", state)
+	}
+
+	// a successful request closes it
+	send()
+	if state := client.BreakerState(); state != BreakerClosed {
+		t.Errorf("Expected a closed breaker once the cluster recovered, got %s", state)
+	}
+	server.Lock()
+	defer server.Unlock()
+	if len(server.batches) != 4 {
+		t.Errorf("Expected the trial request to be sent, got %d bulk requests", len(server.batches))
+	}
+}
+
+func TestFlush(t *testing.T) {
+	server := newBulkServer()
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+	if err := client.Flush(); err != ErrNotStarted {
+		t.Errorf("Expected ErrNotStarted, got: %v", err)
+	}
+
+	client = newStartedTestClient(t, server)
+	defer client.Stop()
+
+	if err := client.BulkDelete("flow", []string{"aaa", "bbb", "ccc"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// deletions are sent as a single line each
+	if lines := server.lines(); len(lines) != 3 {
+		t.Errorf("Expected 3 bulk lines once flushed, got %d", len(lines))
+	}
+}
+
+func TestBulkErrors(t *testing.T) {
+	server := newBulkServer()
+	server.failing = true
+	defer server.Close()
+
+	client := newStartedTestClient(t, server)
+	defer client.Stop()
+
+	if err := client.BulkDelete("flow", []string{"aaa"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Flush(); err == nil {
+		t.Error("Expected Flush to report the bulk error")
+	}
+
+	select {
+	case err := <-client.Errors():
+		if err == nil {
+			t.Error("Expected a non nil bulk error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Bulk error not delivered")
+	}
+}
+
+func TestBulkFailedItems(t *testing.T) {
+	server := newBulkServer()
+	server.response = `{"errors": true, "items": [
+		{"index": {"_id": "aaa", "status": 201}},
+		{"index": {"_id": "bbb", "status": 400, "error": {"type": "mapper_parsing_exception"}}},
+		{"delete": {"_id": "ccc", "status": 200}}
+	]}`
+	defer server.Close()
+
+	client := newStartedTestClient(t, server)
+	defer client.Stop()
+
+	if err := client.BulkDelete("flow", []string{"aaa"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := client.Flush()
+	if err == nil || !strings.Contains(err.Error(), "failed item count 1") {
+		t.Errorf("Expected only the failed items to be counted, got: %v", err)
+	}
+}
+
+func TestIndexBulkPipeline(t *testing.T) {
+	server := newBulkServer()
+	defer server.Close()
+
+	client := newStartedTestClient(t, server)
+	defer client.Stop()
+
+	if _, err := client.IndexBulk("flow", map[string]interface{}{"aaa": map[string]string{}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.IndexBulkWithOptions("flow", map[string]interface{}{"aaa": map[string]string{}}, WriteOptions{Pipeline: "geoip"}); err != nil {
+		t.Fatal(err)
+	}
+	// a later write of the same document doesn't go through the pipeline
+	if _, err := client.IndexBulk("flow", map[string]interface{}{"aaa": map[string]string{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var pipelines []interface{}
+	for i, line := range server.lines() {
+		if i%2 == 1 {
+			continue
+		}
+
+		var action map[string]map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &action); err != nil {
+			t.Fatal(err)
+		}
+		pipelines = append(pipelines, action["index"]["pipeline"])
+	}
+
+	if expected := []interface{}{nil, "geoip", nil}; !reflect.DeepEqual(pipelines, expected) {
+		t.Errorf("Expected the pipelines %v in the order of the writes, got %v", expected, pipelines)
+	}
+
+	server.failing = true
+	if _, err := client.IndexBulkWithOptions("flow", map[string]interface{}{"bbb": map[string]string{}}, WriteOptions{Pipeline: "geoip"}); err == nil {
+		t.Error("Expected the failure of the pipeline bulk request to be returned")
+	}
+
+	if _, err := client.IndexBulkWithOptions("flow", nil, WriteOptions{Refresh: RefreshTrue}); !errors.Is(err, ErrIncompatibleOptions) {
+		t.Errorf("Expected the refresh policy to be rejected, got %v", err)
+	}
+}
+
+func TestBulkRetry(t *testing.T) {
+	server := newBulkServer()
+	server.responses = []string{`{"errors": true, "items": [
+		{"index": {"status": 201}},
+		{"index": {"status": 429, "error": {"type": "es_rejected_execution_exception"}}}
+	]}`}
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+	client.SetBulkRetry(3, 10*time.Millisecond)
+	client.startIndexer()
+	defer client.Stop()
+
+	docs := map[string]interface{}{
+		"aaa": map[string]string{"Name": "aaa"},
+		"bbb": map[string]string{"Name": "bbb"},
+	}
+	if _, err := client.IndexBulk("node", docs); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Flush(); err != nil {
+		t.Fatalf("Expected the rejected document to be retried, got: %s", err)
+	}
+
+	server.Lock()
+	defer server.Unlock()
+
+	if len(server.batches) != 2 {
+		t.Fatalf("Expected 2 bulk requests, got %d", len(server.batches))
+	}
+	if first, retry := server.batches[0], server.batches[1]; !reflect.DeepEqual(retry, first[2:]) {
+		t.Errorf("Expected only the rejected document to be retried, got: %v", retry)
+	}
+}
+
+func TestIndexBulk(t *testing.T) {
+	server := newBulkServer()
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+	if _, err := client.IndexBulk("node", nil); err != ErrNotStarted {
+		t.Errorf("Expected ErrNotStarted, got: %v", err)
+	}
+
+	client = newStartedTestClient(t, server)
+	defer client.Stop()
+
+	docs := make(map[string]interface{})
+	for i := 0; i < 1000; i++ {
+		docs[fmt.Sprintf("node-%d", i)] = map[string]interface{}{"Index": i}
+	}
+	docs["invalid"] = func() {}
+
+	rejected, err := client.IndexBulk("node", docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rejected) != 1 || rejected["invalid"] == nil {
+		t.Errorf("Expected only the invalid document to be rejected, got: %v", rejected)
+	}
+
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// each document is sent as an action line followed by the source line
+	lines := server.lines()
+	if len(lines) != 2000 {
+		t.Fatalf("Expected 2000 bulk lines, got %d", len(lines))
+	}
+
+	ids := make(map[string]bool)
+	for i := 0; i < len(lines); i += 2 {
+		var action struct {
+			Index struct {
+				ID string `json:"_id"`
+			} `json:"index"`
+		}
+		if err := json.Unmarshal([]byte(lines[i]), &action); err != nil {
+			t.Fatalf("Invalid bulk action %s: %s", lines[i], err.Error())
+		}
+		ids[action.Index.ID] = true
+	}
+	if len(ids) != 1000 {
+		t.Errorf("Expected 1000 distinct documents, got %d", len(ids))
+	}
+}
+
+func TestBulkImport(t *testing.T) {
+	server := newBulkServer()
+	server.response = `{"errors": true, "items": [
+		{"index": {"_id": "aaa", "status": 201}},
+		{"index": {"_id": "bbb", "status": 400, "error": {"type": "mapper_parsing_exception",
+			"reason": "failed to parse [Metadata.MTU]"}}}
+	]}`
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+	failures, err := client.BulkImport("node", map[string]interface{}{
+		"aaa": map[string]interface{}{"Metadata": map[string]interface{}{"MTU": 1500}},
+		"bbb": map[string]interface{}{"Metadata": map[string]interface{}{"MTU": "none"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := server.lines(); len(lines) != 4 {
+		t.Errorf("Expected 4 bulk lines, got %d", len(lines))
+	}
+
+	expected := []BulkItemError{{ID: "bbb", Status: 400, Type: "mapper_parsing_exception", Reason: "failed to parse [Metadata.MTU]"}}
+	if !reflect.DeepEqual(failures, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, failures)
+	}
+}
+
+func TestBulkReconnect(t *testing.T) {
+	healthPollInterval = 10 * time.Millisecond
+	defer func() { healthPollInterval = time.Second }()
+
+	var lock sync.Mutex
+	var bulks int
+	down := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/_bulk" {
+			bulks++
+		}
+		w.Write([]byte(`{"status": "green", "errors": false, "items": []}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	client.SetBulkMaxFailures(2)
+	client.startIndexer()
+	defer client.Stop()
+
+	indexer := client.bulkIndexer()
+	for i := 0; i < 2; i++ {
+		client.BulkDelete("flow", []string{"aaa"})
+		if err := client.Flush(); err == nil {
+			t.Fatal("Expected the bulk to fail while the server is down")
+		}
+	}
+
+	lock.Lock()
+	down = false
+	lock.Unlock()
+
+	for start := time.Now(); client.bulkIndexer() == indexer; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("Bulk indexer not recreated")
+		}
+	}
+
+	client.BulkDelete("flow", []string{"bbb"})
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if bulks != 1 {
+		t.Errorf("Expected indexing to resume, got %d bulk requests", bulks)
+	}
+}
+
+func TestPendingDocs(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+		w.Write([]byte(`{"errors": false, "items": []}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	client.startIndexer()
+	defer client.Stop()
+
+	if err := client.BulkDelete("flow", []string{"aaa", "bbb", "ccc"}); err != nil {
+		t.Fatal(err)
+	}
+	if pending := client.PendingDocs(); pending != 3 {
+		t.Errorf("Expected 3 pending documents, got %d", pending)
+	}
+
+	flushed := make(chan error)
+	go func() {
+		flushed <- client.Flush()
+	}()
+
+	<-received
+	if depth := client.QueueDepth(); depth != 1 {
+		t.Errorf("Expected 1 bulk request being sent, got %d", depth)
+	}
+	close(release)
+
+	if err := <-flushed; err != nil {
+		t.Fatal(err)
+	}
+	if pending, depth := client.PendingDocs(), client.QueueDepth(); pending != 0 || depth != 0 {
+		t.Errorf("Expected nothing pending once flushed, got %d documents and %d requests", pending, depth)
+	}
+}
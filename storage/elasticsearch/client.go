@@ -23,12 +23,24 @@
 package elasticsearch
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -41,37 +53,429 @@ import (
 
 const indexVersion = 3
 
+// defaultIndexPrefix is the name of the alias, and the prefix of the index
+// names, when not configured
+const defaultIndexPrefix = "skydive"
+
 const (
 	AscendingOrder = iota
 	DescendingOrder
 )
 
+// retrySleep waits between two attempts to start the client
+var retrySleep = time.Sleep
+
+// hostDeadDelay is the time during which a host that failed to answer is
+// skipped by the round-robin selection
+const hostDeadDelay = 30 * time.Second
+
+// requestRetryDelay is the delay before the first retry of a failed document
+// request, the delay doubles after each attempt
+var requestRetryDelay = 100 * time.Millisecond
+
+// maxResultWindow is the default index.max_result_window setting, the
+// maximum from+size value of a search
+const maxResultWindow = 10000
+
 type ElasticSearchClient struct {
 	connection *elastigo.Conn
 	indexer    *elastigo.BulkIndexer
 	started    atomic.Value
+	stopping   atomic.Value
+	stopped    chan struct{}
+	stopOnce   sync.Once
+	hosts      *hostPool
+	httpClient *http.Client
+	transport  *http.Transport
+	compress   bool
+	alias      string
+	index      string
+	version    int
+	shards     int
+	replicas   int
+	codec      string
+	analysis   json.RawMessage
+
+	allowScripts bool
+	useTemplate  bool
+	rolling      bool
+	rolled       atomic.Value
+	indexPerType bool
+	types        []string
+	parentTypes  map[string]bool
+	keywords     map[string]bool
+	dynamic      map[string]string
+	metrics      MetricsHandler
+
+	healthStatus  string
+	healthTimeout time.Duration
+
+	maxRetryDelay  time.Duration
+	connectTimeout time.Duration
+
+	maxAttempts    int
+	retryTimeout   time.Duration
+	requestTimeout time.Duration
+	opaqueIDPrefix string
+	opaqueIDs      uint32
+
+	bulkErrLock sync.Mutex
+	bulkErr     error
+	bulkErrors  chan error
+
+	indexerLock     sync.RWMutex
+	bulkMaxConns    int
+	bulkFailures    int32
+	bulkMaxFailures int
+	bulkInFlight    int32
+	drainTimeout    time.Duration
+	bulkMaxRetries  int
+	bulkRetryDelay  time.Duration
+	retryLock       sync.Mutex
+	retryCond       *sync.Cond
+	retrying        int
+	breaker         *circuitBreaker
+	maxPending      int
+	pendingPolicy   string
+	pending         *pendingQueue
+
+	mappingLock  sync.Mutex
+	mappingCache map[string]map[string]interface{}
+	quit         chan struct{}
+	wg           sync.WaitGroup
 }
 
 var ErrBadConfig = errors.New("elasticsearch : Config file is misconfigured, check elasticsearch key format")
+var ErrAliasCreation = errors.New("elasticsearch : Unable to create the index alias")
+var ErrNotStarted = errors.New("elasticsearch : client not started")
+var ErrStopped = errors.New("elasticsearch : client stopped")
+var ErrMappingConflict = errors.New("elasticsearch : Mapping conflicts with the existing index mapping, the index has to be migrated")
+var ErrIncompatibleOptions = errors.New("elasticsearch : Incompatible client options")
+var ErrNewerIndexExists = errors.New("elasticsearch : An index with a newer version exists, the data would be split across versions")
+var ErrResultWindowExceeded = errors.New("elasticsearch : Result window is too large, use SearchScroll instead")
+var ErrBadSortOrder = errors.New("elasticsearch : Sort order has to be AscendingOrder or DescendingOrder")
+var ErrNotFound = errors.New("elasticsearch : document not found")
+var ErrVersionConflict = errors.New("elasticsearch : document modified since the expected version")
+var ErrRequestTimeout = errors.New("elasticsearch : request timed out")
+var ErrBadAuthConfig = errors.New("elasticsearch : Config file is misconfigured, both username and password have to be set")
 
-func (c *ElasticSearchClient) request(method string, path string, query string, body string) (int, []byte, error) {
-	req, err := c.connection.NewRequest(method, path, query)
+// hostPool selects Elasticsearch hosts in a round-robin way, skipping the
+// ones that recently returned a connection error
+type hostPool struct {
+	sync.Mutex
+	hosts []string
+	next  int
+	dead  map[string]time.Time
+}
+
+func (p *hostPool) get() string {
+	p.Lock()
+	defer p.Unlock()
+
+	now := time.Now()
+	for range p.hosts {
+		host := p.hosts[p.next]
+		p.next = (p.next + 1) % len(p.hosts)
+
+		if until, ok := p.dead[host]; !ok || now.After(until) {
+			return host
+		}
+	}
+
+	// all the hosts are marked as dead, try the next one anyway
+	host := p.hosts[p.next]
+	p.next = (p.next + 1) % len(p.hosts)
+	return host
+}
+
+func (p *hostPool) markDead(host string) {
+	p.Lock()
+	p.dead[host] = time.Now().Add(hostDeadDelay)
+	p.Unlock()
+}
+
+func (p *hostPool) markAlive(host string) {
+	p.Lock()
+	delete(p.dead, host)
+	p.Unlock()
+}
+
+// reset marks all the hosts as alive
+func (p *hostPool) reset() {
+	p.Lock()
+	p.dead = make(map[string]time.Time)
+	p.Unlock()
+}
+
+func newHostPool(hosts []string) *hostPool {
+	return &hostPool{
+		hosts: hosts,
+		dead:  make(map[string]time.Time),
+	}
+}
+
+func newResponseError(code int, data []byte) error {
+	return elastigo.ESError{When: time.Now(), What: string(data), Code: code}
+}
+
+func (c *ElasticSearchClient) requestHost(ctx context.Context, host string, opaqueID string, method string, path string, query string, body string) (int, []byte, error) {
+	uri := fmt.Sprintf("%s://%s%s", c.connection.Protocol, host, path)
+	if query != "" {
+		uri += "?" + query
+	}
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+
+	if body != "" && c.compress {
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write([]byte(body)); err != nil {
+			return 503, nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return 503, nil, err
+		}
+		reader = &buf
+	}
+
+	req, err := http.NewRequest(method, uri, reader)
 	if err != nil {
 		return 503, nil, err
 	}
-
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if opaqueID != "" {
+		req.Header.Set("X-Opaque-Id", opaqueID)
+	}
+	if c.connection.Username != "" || c.connection.Password != "" {
+		req.SetBasicAuth(c.connection.Username, c.connection.Password)
+	}
 	if body != "" {
-		req.SetBodyString(body)
+		// the content type is the one of the uncompressed body
+		req.Header.Set("Content-Type", "application/json")
+		if c.compress {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 503, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 503, nil, err
+	}
+
+	return resp.StatusCode, data, nil
+}
+
+func (c *ElasticSearchClient) request(method string, path string, query string, body string) (int, []byte, error) {
+	return c.requestContext(context.Background(), method, path, query, body)
+}
+
+func (c *ElasticSearchClient) requestContext(ctx context.Context, method string, path string, query string, body string) (code int, data []byte, err error) {
+	// the default timeout only applies when the caller didn't set a deadline
+	requestCtx := ctx
+	if _, found := ctx.Deadline(); !found && c.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		requestCtx, cancel = context.WithTimeout(ctx, c.requestTimeout)
+		defer cancel()
+	}
+
+	// the id is echoed by Elasticsearch in its logs and tasks, it is logged
+	// along with the errors for them to be correlated
+	var opaqueID, tag string
+	if c.opaqueIDPrefix != "" {
+		opaqueID = fmt.Sprintf("%s-%d", c.opaqueIDPrefix, atomic.AddUint32(&c.opaqueIDs, 1))
+		tag = " (X-Opaque-Id " + opaqueID + ")"
+	}
+
+	// each host is tried at most once, a host failing to answer is skipped
+	// for the next requests
+	for range c.hosts.hosts {
+		host := c.hosts.get()
+		if code, data, err = c.requestHost(requestCtx, host, opaqueID, method, path, query, body); err == nil {
+			c.hosts.markAlive(host)
+			return
+		}
+
+		// the request was cancelled by the caller, the host is not to blame
+		if ctx.Err() != nil {
+			return code, data, ctx.Err()
+		}
+		// neither is it for a slow request
+		if requestCtx.Err() != nil {
+			return code, data, fmt.Errorf("%w: %s %s took more than %s%s", ErrRequestTimeout, method, path, c.requestTimeout, tag)
+		}
+		c.hosts.markDead(host)
+
+		logging.GetLogger().Warningf("Elasticsearch request to %s failed%s: %s", host, tag, err.Error())
+	}
+	if err != nil && tag != "" {
+		err = fmt.Errorf("%w%s", err, tag)
+	}
+	return
+}
+
+// Do sends a raw request to an endpoint not wrapped by the client, as
+// _cat/indices or _nodes/stats, going through the same host selection as the
+// other requests. The status code is returned as is, the caller has to check
+// it as a non successful status code is not reported as an error.
+func (c *ElasticSearchClient) Do(method string, path string, query string, body string) (int, []byte, error) {
+	return c.requestContext(context.Background(), method, path, query, body)
+}
+
+// DoWithContext sends a raw request, aborted when the context is cancelled
+func (c *ElasticSearchClient) DoWithContext(ctx context.Context, method string, path string, query string, body string) (int, []byte, error) {
+	return c.requestContext(ctx, method, path, query, body)
+}
+
+// jsonRequest sends a request and decodes the JSON response into result,
+// a non successful status code is returned as an error
+func (c *ElasticSearchClient) jsonRequest(method string, path string, query string, body string, result interface{}) error {
+	return c.jsonRequestContext(context.Background(), method, path, query, body, result)
+}
+
+func (c *ElasticSearchClient) jsonRequestContext(ctx context.Context, method string, path string, query string, body string, result interface{}) error {
+	data, err := c.rawRequestContext(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+
+	if result != nil {
+		return json.Unmarshal(data, result)
+	}
+	return nil
+}
+
+// rawRequestContext sends a request and returns the undecoded response body,
+// a non successful status code is returned as an error
+func (c *ElasticSearchClient) rawRequestContext(ctx context.Context, method string, path string, query string, body string) ([]byte, error) {
+	code, data, err := c.requestContext(ctx, method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if code == http.StatusNotFound {
+		return nil, elastigo.RecordNotFound
+	}
+	if code < 200 || code >= 300 {
+		return nil, newResponseError(code, data)
+	}
+	return data, nil
+}
+
+// searchRequest sends a search request, the raw response being kept in the
+// result for TotalHits to read the total whatever its format
+func (c *ElasticSearchClient) searchRequest(ctx context.Context, path string, query string, body string) (elastigo.SearchResult, error) {
+	data, err := c.rawRequestContext(ctx, "POST", path, query, body)
+	if err != nil {
+		return elastigo.SearchResult{}, err
 	}
 
-	var response map[string]interface{}
-	return req.Do(&response)
+	var result elastigo.SearchResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		// the total is an object starting with Elasticsearch 7, the other
+		// fields are decoded anyway
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) || typeErr.Field != "hits.total" {
+			return elastigo.SearchResult{}, err
+		}
+	}
+	result.RawJSON = data
+
+	return result, nil
+}
+
+// retryable returns whether a failed request may succeed if sent again,
+// which is the case of the connection errors and of the server errors
+func retryable(err error) bool {
+	var esErr elastigo.ESError
+	if errors.As(err, &esErr) {
+		return esErr.Code >= 500
+	}
+
+	// retrying a slow request would only stall the caller longer
+	if errors.Is(err, ErrRequestTimeout) {
+		return false
+	}
+
+	var urlErr *url.Error
+	var netErr net.Error
+	return errors.As(err, &urlErr) || errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// idempotent returns whether sending a request several times has the same
+// effect as sending it once, a POST creating a document with a generated id
+// would be duplicated for instance
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// retryJSONRequest sends a JSON request, retrying the idempotent ones with a
+// backoff on connection or server errors, at most maxAttempts times and as
+// long as the retry timeout did not expire
+func (c *ElasticSearchClient) retryJSONRequest(ctx context.Context, method string, path string, query string, body string, result interface{}) (err error) {
+	deadline := time.Now().Add(c.retryTimeout)
+	delay := requestRetryDelay
+
+	for attempt := 1; ; attempt++ {
+		if err = c.jsonRequestContext(ctx, method, path, query, body, result); err == nil || ctx.Err() != nil || !idempotent(method) || !retryable(err) {
+			return
+		}
+
+		if attempt >= c.maxAttempts || time.Now().Add(delay).After(deadline) {
+			return
+		}
+
+		logging.GetLogger().Debugf("Retrying %s %s in %s: %s", method, path, delay, err.Error())
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
 }
 
 func (c *ElasticSearchClient) createAlias() error {
-	aliases := `{"actions": [`
+	if c.indexPerType {
+		for _, obj := range c.types {
+			if err := c.swapAlias(c.typeAlias(obj), c.typeIndex(obj)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return c.SwapAlias(c.indexPattern())
+}
+
+// SwapAlias moves the alias from the indices currently holding it to
+// newIndex, in a single atomic request so that the alias always points to
+// an index, which allows migrating to a new index without downtime
+func (c *ElasticSearchClient) SwapAlias(newIndex string) error {
+	return c.swapAlias(c.AliasName(), newIndex)
+}
 
-	code, data, _ := c.request("GET", "/_aliases", "", "")
+func (c *ElasticSearchClient) swapAlias(alias string, newIndex string) error {
+	// only the indices holding the alias are returned, none being a 404
+	code, data, err := c.request("GET", "/_alias/"+alias, "", "")
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrAliasCreation, err.Error())
+	}
+
+	var actions []map[string]map[string]string
 	if code == http.StatusOK {
 		var current map[string]interface{}
 
@@ -80,39 +484,75 @@ func (c *ElasticSearchClient) createAlias() error {
 			return errors.New("Unable to parse aliases: " + err.Error())
 		}
 
-		for k := range current {
-			if strings.HasPrefix(k, "skydive_") {
-				remove := `{"remove":{"alias": "skydive", "index": "%s"}},`
-				aliases += fmt.Sprintf(remove, k)
-			}
+		var indices []string
+		for index := range current {
+			indices = append(indices, index)
+		}
+		sort.Strings(indices)
+
+		for _, index := range indices {
+			actions = append(actions, map[string]map[string]string{
+				"remove": {"alias": alias, "index": index},
+			})
 		}
 	}
 
-	add := `{"add":{"alias": "skydive", "index": "skydive_v%d"}}]}`
-	aliases += fmt.Sprintf(add, indexVersion)
+	actions = append(actions, map[string]map[string]string{
+		"add": {"alias": alias, "index": newIndex},
+	})
+	aliases, _ := json.Marshal(map[string]interface{}{"actions": actions})
+
+	code, data, err = c.request("POST", "/_aliases", "", string(aliases))
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrAliasCreation, err.Error())
+	}
 
-	code, _, _ = c.request("POST", "/_aliases", "", aliases)
 	if code != http.StatusOK {
-		return errors.New("Unable to create an alias to the skydive index: " + strconv.FormatInt(int64(code), 10))
+		return fmt.Errorf("%w: status code %d: %s", ErrAliasCreation, code, string(data))
 	}
 
 	return nil
 }
 
+// openIndex opens the given index, creating it if it doesn't exist yet
+func (c *ElasticSearchClient) openIndex(index string) error {
+	indexPath := "/" + index
+	if err := c.jsonRequest("POST", indexPath+"/_open", "", "", nil); err != nil {
+		if err := c.jsonRequest("PUT", indexPath, "", c.indexSettings(), nil); err != nil {
+			return fmt.Errorf("Unable to create the %s index: %s", index, err.Error())
+		}
+	}
+	return nil
+}
+
 func (c *ElasticSearchClient) start(mappings []map[string][]byte) error {
-	indexPath := fmt.Sprintf("/skydive_v%d", indexVersion)
+	c.types = mappingTypes(mappings)
+	c.parentTypes = parentTypes(mappings)
+
+	if err := c.checkNewerIndex(); err != nil {
+		return err
+	}
 
-	if _, err := c.connection.OpenIndex(indexPath); err != nil {
-		if _, err := c.connection.CreateIndex(indexPath); err != nil {
-			return errors.New("Unable to create the skydive index: " + err.Error())
+	if c.useTemplate {
+		if err := c.putTemplate(mappings); err != nil {
+			return err
 		}
 	}
 
-	for _, document := range mappings {
-		for obj, mapping := range document {
-			if err := c.connection.PutMappingFromJSON(indexPath, obj, []byte(mapping)); err != nil {
-				return fmt.Errorf("Unable to create %s mapping: %s", obj, err.Error())
-			}
+	for _, index := range c.indices() {
+		if err := c.openIndex(index); err != nil {
+			return err
+		}
+	}
+
+	if err := c.waitForHealth(); err != nil {
+		return err
+	}
+
+	// with a template, the mappings are set when the index is created
+	if !c.useTemplate {
+		if err := c.putMappings(mappings); err != nil {
+			return err
 		}
 	}
 
@@ -120,14 +560,33 @@ func (c *ElasticSearchClient) start(mappings []map[string][]byte) error {
 		return err
 	}
 
-	c.indexer.Start()
-	c.started.Store(true)
+	if c.rolling {
+		if err := c.loadRolledIndex(); err != nil {
+			return err
+		}
+	}
+
+	c.startIndexer()
 
 	logging.GetLogger().Infof("ElasticSearchStorage started")
 
 	return nil
 }
 
+// arrayLenOperators are the painless comparisons of the array length filters
+var arrayLenOperators = map[string]string{"eq": "==", "gt": ">", "gte": ">=", "lt": "<", "lte": "<="}
+
+// matchNone returns a query matching no document
+func matchNone() map[string]interface{} {
+	return map[string]interface{}{
+		"bool": map[string]interface{}{
+			"must_not": map[string]interface{}{
+				"match_all": map[string]interface{}{},
+			},
+		},
+	}
+}
+
 func (c *ElasticSearchClient) FormatFilter(filter *filters.Filter, prefix string) map[string]interface{} {
 	if filter == nil {
 		return map[string]interface{}{
@@ -156,10 +615,166 @@ func (c *ElasticSearchClient) FormatFilter(filter *filters.Filter, prefix string
 		}
 	}
 
+	if f := filter.NestedFilter; f != nil {
+		// fields of the inner query have to be referenced by their full
+		// path, including the nested object path
+		path := prefix + f.Path
+		return map[string]interface{}{
+			"nested": map[string]interface{}{
+				"path":  path,
+				"query": c.FormatFilter(f.Filter, path+"."),
+			},
+		}
+	}
+
+	// term queries match the exact value and are meant for keyword fields,
+	// the text fields being analyzed into lower case words use a match query
+	// or their keyword sub-field
 	if f := filter.TermStringFilter; f != nil {
+		field := prefix + f.Key
+		if c.keywords[field] {
+			field += ".keyword"
+		}
 		return map[string]interface{}{
 			"term": map[string]string{
-				prefix + f.Key: f.Value,
+				field: f.Value,
+			},
+		}
+	}
+	if f := filter.MatchFilter; f != nil {
+		if f.Operator == "" {
+			return map[string]interface{}{
+				"match": map[string]string{
+					prefix + f.Key: f.Value,
+				},
+			}
+		}
+		return map[string]interface{}{
+			"match": map[string]interface{}{
+				prefix + f.Key: map[string]string{
+					"query":    f.Value,
+					"operator": f.Operator,
+				},
+			},
+		}
+	}
+	if f := filter.DateRangeFilter; f != nil {
+		// the date math expressions are evaluated by elasticsearch
+		return rangeQuery(prefix+f.Key, map[string]string{
+			"gte": f.From,
+			"lte": f.To,
+		}, f.RequireExists)
+	}
+	if f := filter.TermsStringFilter; f != nil {
+		// an empty terms query would match everything, none of the values
+		// can match in that case
+		if len(f.Values) == 0 {
+			return matchNone()
+		}
+		return map[string]interface{}{
+			"terms": map[string][]string{
+				prefix + f.Key: f.Values,
+			},
+		}
+	}
+	if f := filter.TermsInt64Filter; f != nil {
+		if len(f.Values) == 0 {
+			return matchNone()
+		}
+		return map[string]interface{}{
+			"terms": map[string][]int64{
+				prefix + f.Key: f.Values,
+			},
+		}
+	}
+	if f := filter.IPRangeFilter; f != nil {
+		if _, _, err := f.Bounds(); err != nil {
+			logging.GetLogger().Errorf("Invalid network %s for %s: %s", f.CIDR, f.Key, err.Error())
+			return matchNone()
+		}
+
+		// ip fields support the CIDR notation, keyword fields are compared
+		// as strings so the addresses are matched by a regular expression
+		if f.IPField {
+			return map[string]interface{}{
+				"term": map[string]string{
+					prefix + f.Key: f.CIDR,
+				},
+			}
+		}
+
+		re, err := f.Regexp()
+		if err != nil {
+			logging.GetLogger().Errorf("Unable to match the network %s for %s: %s", f.CIDR, f.Key, err.Error())
+			return matchNone()
+		}
+		return map[string]interface{}{
+			"regexp": map[string]string{
+				prefix + f.Key: re,
+			},
+		}
+	}
+	if f := filter.GeoDistanceFilter; f != nil {
+		if _, err := f.Meters(); err != nil {
+			logging.GetLogger().Errorf("Invalid geo distance filter for %s: %s", f.Key, err.Error())
+			return matchNone()
+		}
+
+		return map[string]interface{}{
+			"geo_distance": map[string]interface{}{
+				"distance": f.Distance,
+				prefix + f.Key: map[string]float64{
+					"lat": f.Lat,
+					"lon": f.Lon,
+				},
+			},
+		}
+	}
+	if f := filter.ScriptFilter; f != nil {
+		if !c.allowScripts {
+			logging.GetLogger().Errorf("Script filters are not allowed, see storage.elasticsearch.allow_scripts")
+			return matchNone()
+		}
+		if strings.TrimSpace(f.Source) == "" {
+			logging.GetLogger().Errorf("Empty script filter")
+			return matchNone()
+		}
+
+		script := map[string]interface{}{
+			c.scriptSourceKey(): f.Source,
+			"lang":              "painless",
+		}
+		if len(f.Params) > 0 {
+			script["params"] = f.Params
+		}
+		return map[string]interface{}{
+			"script": map[string]interface{}{
+				"script": script,
+			},
+		}
+	}
+	if f := filter.ArrayLenFilter; f != nil {
+		if !c.allowScripts {
+			logging.GetLogger().Errorf("Array length filters are run as scripts which are not allowed, see storage.elasticsearch.allow_scripts")
+			return matchNone()
+		}
+
+		operator, ok := arrayLenOperators[f.Operator]
+		if !ok {
+			logging.GetLogger().Errorf("Invalid array length operator %s for %s", f.Operator, f.Key)
+			return matchNone()
+		}
+
+		return map[string]interface{}{
+			"script": map[string]interface{}{
+				"script": map[string]interface{}{
+					c.scriptSourceKey(): "doc[params.field].size() " + operator + " params.value",
+					"lang":              "painless",
+					"params": map[string]interface{}{
+						"field": prefix + f.Key,
+						"value": f.Value,
+					},
+				},
 			},
 		}
 	}
@@ -170,6 +785,13 @@ func (c *ElasticSearchClient) FormatFilter(filter *filters.Filter, prefix string
 			},
 		}
 	}
+	if f := filter.TermBoolFilter; f != nil {
+		return map[string]interface{}{
+			"term": map[string]bool{
+				prefix + f.Key: f.Value,
+			},
+		}
+	}
 
 	if f := filter.RegexFilter; f != nil {
 		return map[string]interface{}{
@@ -179,100 +801,918 @@ func (c *ElasticSearchClient) FormatFilter(filter *filters.Filter, prefix string
 		}
 	}
 
-	if f := filter.GtInt64Filter; f != nil {
+	if f := filter.PrefixFilter; f != nil {
 		return map[string]interface{}{
-			"range": map[string]interface{}{
-				prefix + f.Key: &struct {
-					Gt interface{} `json:"gt,omitempty"`
-				}{
-					Gt: f.Value,
-				},
+			"prefix": map[string]string{
+				prefix + f.Key: f.Value,
 			},
 		}
 	}
-	if f := filter.LtInt64Filter; f != nil {
+	if f := filter.WildcardFilter; f != nil {
 		return map[string]interface{}{
-			"range": map[string]interface{}{
-				prefix + f.Key: &struct {
-					Lt interface{} `json:"lt,omitempty"`
-				}{
-					Lt: f.Value,
-				},
+			"wildcard": map[string]string{
+				prefix + f.Key: f.Value,
 			},
 		}
 	}
-	if f := filter.GteInt64Filter; f != nil {
+
+	if f := filter.ExistsFilter; f != nil {
 		return map[string]interface{}{
-			"range": map[string]interface{}{
-				prefix + f.Key: &struct {
-					Gte interface{} `json:"gte,omitempty"`
-				}{
-					Gte: f.Value,
-				},
+			"exists": map[string]string{
+				"field": prefix + f.Key,
 			},
 		}
 	}
-	if f := filter.LteInt64Filter; f != nil {
+	if f := filter.NotExistsFilter; f != nil {
 		return map[string]interface{}{
-			"range": map[string]interface{}{
-				prefix + f.Key: &struct {
-					Lte interface{} `json:"lte,omitempty"`
-				}{
-					Lte: f.Value,
+			"bool": map[string]interface{}{
+				"must_not": map[string]interface{}{
+					"exists": map[string]string{
+						"field": prefix + f.Key,
+					},
 				},
 			},
 		}
 	}
+
+	if f := filter.GtInt64Filter; f != nil {
+		return rangeQuery(prefix+f.Key, &struct {
+			Gt interface{} `json:"gt,omitempty"`
+		}{
+			Gt: f.Value,
+		}, f.RequireExists)
+	}
+	if f := filter.LtInt64Filter; f != nil {
+		return rangeQuery(prefix+f.Key, &struct {
+			Lt interface{} `json:"lt,omitempty"`
+		}{
+			Lt: f.Value,
+		}, f.RequireExists)
+	}
+	if f := filter.GteInt64Filter; f != nil {
+		return rangeQuery(prefix+f.Key, &struct {
+			Gte interface{} `json:"gte,omitempty"`
+		}{
+			Gte: f.Value,
+		}, f.RequireExists)
+	}
+	if f := filter.LteInt64Filter; f != nil {
+		return rangeQuery(prefix+f.Key, &struct {
+			Lte interface{} `json:"lte,omitempty"`
+		}{
+			Lte: f.Value,
+		}, f.RequireExists)
+	}
+	if f := filter.GtFloat64Filter; f != nil {
+		return rangeQuery(prefix+f.Key, &struct {
+			Gt interface{} `json:"gt,omitempty"`
+		}{
+			Gt: f.Value,
+		}, f.RequireExists)
+	}
+	if f := filter.LtFloat64Filter; f != nil {
+		return rangeQuery(prefix+f.Key, &struct {
+			Lt interface{} `json:"lt,omitempty"`
+		}{
+			Lt: f.Value,
+		}, f.RequireExists)
+	}
+	if f := filter.GteFloat64Filter; f != nil {
+		return rangeQuery(prefix+f.Key, &struct {
+			Gte interface{} `json:"gte,omitempty"`
+		}{
+			Gte: f.Value,
+		}, f.RequireExists)
+	}
+	if f := filter.LteFloat64Filter; f != nil {
+		return rangeQuery(prefix+f.Key, &struct {
+			Lte interface{} `json:"lte,omitempty"`
+		}{
+			Lte: f.Value,
+		}, f.RequireExists)
+	}
+	if f := filter.RangeFilter; f != nil {
+		bounds := make(map[string]int64)
+		if f.Gt != nil {
+			bounds["gt"] = f.Gt.Value
+		}
+		if f.Gte != nil {
+			bounds["gte"] = f.Gte.Value
+		}
+		if f.Lt != nil {
+			bounds["lt"] = f.Lt.Value
+		}
+		if f.Lte != nil {
+			bounds["lte"] = f.Lte.Value
+		}
+		return rangeQuery(prefix+f.Key, bounds, f.RequireExists)
+	}
 	return nil
 }
 
+// rangeQuery returns a range query on field, guarded by an exists query when
+// the field is required
+func rangeQuery(field string, bounds interface{}, requireExists bool) map[string]interface{} {
+	query := map[string]interface{}{
+		"range": map[string]interface{}{
+			field: bounds,
+		},
+	}
+	if !requireExists {
+		return query
+	}
+
+	return map[string]interface{}{
+		"bool": map[string]interface{}{
+			"must": []interface{}{
+				map[string]interface{}{
+					"exists": map[string]string{
+						"field": field,
+					},
+				},
+				query,
+			},
+		},
+	}
+}
+
+// FormatSort returns the sort clause for the given field and order
+func (c *ElasticSearchClient) FormatSort(field string, order int) (map[string]interface{}, error) {
+	var direction string
+	switch order {
+	case AscendingOrder:
+		direction = "asc"
+	case DescendingOrder:
+		direction = "desc"
+	default:
+		return nil, ErrBadSortOrder
+	}
+
+	return map[string]interface{}{
+		field: map[string]string{
+			"order": direction,
+		},
+	}, nil
+}
+
+// mergeQuery adds the given top level fields to a JSON query body
+func mergeQuery(query string, fields map[string]interface{}) (string, error) {
+	request := make(map[string]interface{})
+	if query != "" {
+		if err := json.Unmarshal([]byte(query), &request); err != nil {
+			return "", fmt.Errorf("Unable to parse query: %s", err.Error())
+		}
+	}
+
+	for k, v := range fields {
+		request[k] = v
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+func (c *ElasticSearchClient) indexDocument(ctx context.Context, obj string, id string, parent string, query string, data interface{}, result interface{}) error {
+	body, err := c.documentBody(obj, parent, data)
+	if err != nil {
+		return err
+	}
+
+	method, path := "POST", c.writePath(obj)
+	if id != "" {
+		method, path = "PUT", path+"/"+id
+	}
+
+	start := time.Now()
+	err = c.retryJSONRequest(ctx, method, path, query, string(body), result)
+	c.metrics.OnIndex(time.Since(start), err)
+
+	return err
+}
+
 func (c *ElasticSearchClient) Index(obj string, id string, data interface{}) error {
-	_, err := c.connection.Index("skydive", obj, id, nil, data)
+	return c.IndexWithContext(context.Background(), obj, id, data)
+}
+
+// IndexWithContext indexes a document, the request is aborted when the
+// context is cancelled
+func (c *ElasticSearchClient) IndexWithContext(ctx context.Context, obj string, id string, data interface{}) error {
+	return c.indexDocument(ctx, obj, id, "", "", data, nil)
+}
+
+// IndexWithOptions indexes a document with the given write options
+func (c *ElasticSearchClient) IndexWithOptions(obj string, id string, data interface{}, opts WriteOptions) error {
+	query, err := opts.query()
+	if err != nil {
+		return err
+	}
+	return versionConflict(c.indexDocument(context.Background(), obj, id, "", query, data, nil))
+}
+
+// IndexAuto indexes a document under an id generated by Elasticsearch, which
+// spares the lookup of an existing document for append only data. The
+// generated id is returned. The request is not retried as a retry could
+// index the document twice.
+func (c *ElasticSearchClient) IndexAuto(obj string, data interface{}) (string, error) {
+	var response struct {
+		ID string `json:"_id"`
+	}
+	if err := c.indexDocument(context.Background(), obj, "", "", "", data, &response); err != nil {
+		return "", err
+	}
+	return response.ID, nil
+}
+
+// versionConflict returns ErrVersionConflict when the document was modified
+// since the expected version
+func versionConflict(err error) error {
+	var esErr elastigo.ESError
+	if errors.As(err, &esErr) && esErr.Code == http.StatusConflict {
+		return fmt.Errorf("%w: %s", ErrVersionConflict, esErr.What)
+	}
 	return err
 }
 
+// RefreshPolicy selects when the changes made by a write become searchable
+type RefreshPolicy string
+
+const (
+	// RefreshFalse lets the changes be searchable after the next periodic
+	// refresh, the default
+	RefreshFalse RefreshPolicy = "false"
+	// RefreshTrue refreshes the shards right after the write, which creates
+	// a lot of small segments and considerably reduces the throughput, to
+	// keep for tests or for rare writes
+	RefreshTrue RefreshPolicy = "true"
+	// RefreshWaitFor waits for the next periodic refresh before returning
+	RefreshWaitFor RefreshPolicy = "wait_for"
+)
+
+// WriteOptions holds the optional parameters of the document writes
+type WriteOptions struct {
+	// Refresh is the refresh policy of the write, the cluster default when
+	// empty
+	Refresh RefreshPolicy
+	// Version is the expected version of the document, the write failing
+	// with ErrVersionConflict if it was modified meanwhile. Updates have to
+	// use IfSeqNo and IfPrimaryTerm instead starting with Elasticsearch 7.
+	Version int64
+	// IfSeqNo and IfPrimaryTerm are the expected sequence number and primary
+	// term of the document, from Elasticsearch 6.7, only used when the
+	// primary term is set
+	IfSeqNo       int64
+	IfPrimaryTerm int64
+	// Pipeline is the ingest pipeline the indexed documents go through,
+	// none when empty
+	Pipeline string
+}
+
+// query returns the query string of a write
+func (o WriteOptions) query() (string, error) {
+	params := url.Values{}
+
+	if o.Version > 0 {
+		params.Set("version", strconv.FormatInt(o.Version, 10))
+	}
+	if o.IfPrimaryTerm > 0 {
+		params.Set("if_seq_no", strconv.FormatInt(o.IfSeqNo, 10))
+		params.Set("if_primary_term", strconv.FormatInt(o.IfPrimaryTerm, 10))
+	}
+	if o.Pipeline != "" {
+		params.Set("pipeline", o.Pipeline)
+	}
+
+	switch o.Refresh {
+	case "":
+	case RefreshFalse, RefreshTrue, RefreshWaitFor:
+		params.Set("refresh", string(o.Refresh))
+	default:
+		return "", fmt.Errorf("Invalid refresh policy %s", o.Refresh)
+	}
+
+	return params.Encode(), nil
+}
+
+// IndexChildOptions holds the optional parameters of a child document
+type IndexChildOptions struct {
+	// Routing selects the shard of the document, defaults to the parent id
+	// so that children are stored along with their parent
+	Routing string
+	// Timestamp is the creation time of the document, only supported by
+	// the legacy indices
+	Timestamp time.Time
+}
+
 func (c *ElasticSearchClient) IndexChild(obj string, parent string, id string, data interface{}) error {
-	_, err := c.connection.IndexWithParameters("skydive", obj, id, parent, 0, "", "", "", 0, "", "", false, nil, data)
-	return err
+	return c.IndexChildWithOptions(obj, parent, id, data, IndexChildOptions{})
+}
+
+// IndexChildWithOptions indexes a child document of parent
+func (c *ElasticSearchClient) IndexChildWithOptions(obj string, parent string, id string, data interface{}, opts IndexChildOptions) error {
+	query, err := c.childQuery(parent, opts)
+	if err != nil {
+		return err
+	}
+	return c.indexDocument(context.Background(), obj, id, parent, query, data, nil)
 }
 
 func (c *ElasticSearchClient) Update(obj string, id string, data interface{}) error {
-	_, err := c.connection.Update("skydive", obj, id, nil, data)
-	return err
+	return c.UpdateWithOptions(obj, id, data, WriteOptions{})
+}
+
+// UpdateWithOptions updates a document with the given write options
+func (c *ElasticSearchClient) UpdateWithOptions(obj string, id string, data interface{}, opts WriteOptions) error {
+	query, err := opts.query()
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	path, err := c.documentPath(obj, id)
+	if err != nil {
+		return err
+	}
+
+	return versionConflict(c.retryJSONRequest(context.Background(), "POST", path+"/_update", query, string(body), nil))
 }
 
 func (c *ElasticSearchClient) UpdateWithPartialDoc(obj string, id string, data interface{}) error {
-	_, err := c.connection.UpdateWithPartialDoc("skydive", obj, id, nil, data, false)
-	return err
+	return c.Update(obj, id, map[string]interface{}{"doc": data})
+}
+
+// UpdateWithScript updates a document with a painless script, the script
+// being run on an empty document if the document doesn't exist yet
+func (c *ElasticSearchClient) UpdateWithScript(obj string, id string, script string, params map[string]interface{}) error {
+	upsert, err := c.documentBody(obj, "", map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+
+	source := map[string]interface{}{
+		c.scriptSourceKey(): script,
+		"lang":              "painless",
+	}
+	if len(params) > 0 {
+		source["params"] = params
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"script":          source,
+		"scripted_upsert": true,
+		"upsert":          json.RawMessage(upsert),
+	})
+	if err != nil {
+		return err
+	}
+
+	// the document is created in the current index if not found
+	path, err := c.documentPath(obj, id)
+	if err == elastigo.RecordNotFound {
+		path, err = c.writePath(obj)+"/"+id, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// concurrent updates of the same document conflict on its version
+	return c.retryJSONRequest(context.Background(), "POST", path+"/_update", "retry_on_conflict=3", string(body), nil)
 }
 
 func (c *ElasticSearchClient) Get(obj string, id string) (elastigo.BaseResponse, error) {
-	return c.connection.Get("skydive", obj, id, nil)
+	return c.GetWithContext(context.Background(), obj, id)
+}
+
+// GetWithContext retrieves a document, the request is aborted when the
+// context is cancelled
+func (c *ElasticSearchClient) GetWithContext(ctx context.Context, obj string, id string) (elastigo.BaseResponse, error) {
+	var resp elastigo.BaseResponse
+	path, err := c.documentPath(obj, id)
+	if err == nil {
+		err = c.retryJSONRequest(ctx, "GET", path, "", "", &resp)
+	}
+	if err == nil {
+		err = c.checkDocType(obj, &resp)
+	}
+
+	if err == elastigo.RecordNotFound {
+		return elastigo.BaseResponse{}, ErrNotFound
+	}
+	if err != nil {
+		return elastigo.BaseResponse{}, err
+	}
+	return resp, nil
+}
+
+// Exists returns whether a document exists, an error is only returned when
+// the existence can't be determined
+func (c *ElasticSearchClient) Exists(obj string, id string) (bool, error) {
+	// the document is retrieved as its type has to be checked when using
+	// single type indices
+	_, err := c.Get(obj, id)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetInto retrieves a document and decodes its source into out, a missing
+// document not being reported as an error but by found being false
+func (c *ElasticSearchClient) GetInto(obj string, id string, out interface{}) (found bool, err error) {
+	resp, err := c.Get(obj, id)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if resp.Source != nil {
+		if err := json.Unmarshal(*resp.Source, out); err != nil {
+			return true, fmt.Errorf("Unable to decode the %s document %s: %s", obj, id, err)
+		}
+	}
+	return true, nil
+}
+
+// MultiGet retrieves several documents in a single request, the responses
+// are returned in the order of the ids, missing documents having Found unset
+func (c *ElasticSearchClient) MultiGet(obj string, ids []string) ([]elastigo.BaseResponse, error) {
+	var result struct {
+		Docs []elastigo.BaseResponse `json:"docs"`
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	if c.rolling {
+		return c.multiGetSearch(obj, ids)
+	}
+
+	body, err := json.Marshal(map[string][]string{"ids": ids})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.jsonRequest("POST", c.docPath(obj)+"/_mget", "", string(body), &result); err != nil {
+		return nil, err
+	}
+
+	if len(result.Docs) != len(ids) {
+		return nil, fmt.Errorf("Expected %d documents, got %d", len(ids), len(result.Docs))
+	}
+
+	for i := range result.Docs {
+		if result.Docs[i].Found && c.checkDocType(obj, &result.Docs[i]) != nil {
+			result.Docs[i] = elastigo.BaseResponse{Id: ids[i]}
+		}
+	}
+	return result.Docs, nil
 }
 
 func (c *ElasticSearchClient) Delete(obj string, id string) (elastigo.BaseResponse, error) {
-	return c.connection.Delete("skydive", obj, id, nil)
+	return c.DeleteWithOptions(obj, id, WriteOptions{})
+}
+
+// DeleteWithOptions deletes a document with the given write options
+func (c *ElasticSearchClient) DeleteWithOptions(obj string, id string, opts WriteOptions) (elastigo.BaseResponse, error) {
+	query, err := opts.query()
+	if err != nil {
+		return elastigo.BaseResponse{}, err
+	}
+
+	var resp elastigo.BaseResponse
+	path, err := c.documentPath(obj, id)
+	if err != nil {
+		return elastigo.BaseResponse{}, err
+	}
+
+	if err := c.retryJSONRequest(context.Background(), "DELETE", path, query, "", &resp); err != nil {
+		return elastigo.BaseResponse{}, versionConflict(err)
+	}
+	return resp, nil
+}
+
+// DeleteByQuery deletes the documents matching the query and returns the
+// number of deleted documents
+func (c *ElasticSearchClient) DeleteByQuery(obj string, query string) (int64, error) {
+	return c.DeleteByQueryWithContext(context.Background(), obj, query, false)
+}
+
+// DeleteByQueryWithContext deletes the documents matching the query, the
+// request is aborted when the context is cancelled. When proceedOnConflicts
+// is set, the documents modified during the deletion are skipped instead of
+// aborting the deletion.
+func (c *ElasticSearchClient) DeleteByQueryWithContext(ctx context.Context, obj string, query string, proceedOnConflicts bool) (int64, error) {
+	var result struct {
+		Deleted int64 `json:"deleted"`
+	}
+
+	query, err := c.typedQuery(obj, query)
+	if err != nil {
+		return 0, err
+	}
+
+	params := ""
+	if proceedOnConflicts {
+		params = "conflicts=proceed"
+	}
+
+	if err := c.jsonRequestContext(ctx, "POST", c.docPath(obj)+"/_delete_by_query", params, query, &result); err != nil {
+		return 0, err
+	}
+	return result.Deleted, nil
 }
 
 func (c *ElasticSearchClient) Search(obj string, query string) (elastigo.SearchResult, error) {
-	return c.connection.Search("skydive", obj, nil, query)
+	return c.SearchWithContext(context.Background(), obj, query)
+}
+
+// SearchWithContext runs a search query, the request is aborted when the
+// context is cancelled
+func (c *ElasticSearchClient) SearchWithContext(ctx context.Context, obj string, query string) (elastigo.SearchResult, error) {
+	query, err := c.typedQuery(obj, query)
+	if err != nil {
+		return elastigo.SearchResult{}, err
+	}
+
+	start := time.Now()
+	result, err := c.searchRequest(ctx, c.docPath(obj)+"/_search", c.searchParams(""), query)
+	if err != nil {
+		c.metrics.OnSearch(time.Since(start), 0, err)
+		return elastigo.SearchResult{}, err
+	}
+	c.metrics.OnSearch(time.Since(start), len(result.Hits.Hits), nil)
+	c.setHitRelations(result.Hits.Hits)
+
+	return result, nil
+}
+
+// SearchRaw runs a search query and returns the undecoded response, to be
+// forwarded as is without decoding and encoding the whole result again
+func (c *ElasticSearchClient) SearchRaw(obj string, query string) ([]byte, error) {
+	return c.SearchRawWithContext(context.Background(), obj, query)
+}
+
+// SearchRawWithContext runs a search query returning the undecoded response,
+// the request is aborted when the context is cancelled
+func (c *ElasticSearchClient) SearchRawWithContext(ctx context.Context, obj string, query string) ([]byte, error) {
+	query, err := c.typedQuery(obj, query)
+	if err != nil {
+		return nil, err
+	}
+	return c.rawRequestContext(ctx, "POST", c.docPath(obj)+"/_search", c.searchParams(""), query)
+}
+
+// SearchSorted runs a search query sorting the results on the given field
+func (c *ElasticSearchClient) SearchSorted(obj string, query string, field string, order int) (elastigo.SearchResult, error) {
+	sort, err := c.FormatSort(field, order)
+	if err != nil {
+		return elastigo.SearchResult{}, err
+	}
+
+	body, err := mergeQuery(query, map[string]interface{}{"sort": []interface{}{sort}})
+	if err != nil {
+		return elastigo.SearchResult{}, err
+	}
+
+	return c.Search(obj, body)
+}
+
+// Aggregate runs the aggregations on the documents matching the query and
+// returns the aggregations section of the response, no hit is fetched
+func (c *ElasticSearchClient) Aggregate(obj string, query string, aggs map[string]interface{}) (map[string]interface{}, error) {
+	var result struct {
+		Aggregations map[string]interface{} `json:"aggregations"`
+	}
+
+	body, err := mergeQuery(query, map[string]interface{}{"aggs": aggs, "size": 0})
+	if err != nil {
+		return nil, err
+	}
+
+	if body, err = c.typedQuery(obj, body); err != nil {
+		return nil, err
+	}
+
+	if err := c.jsonRequest("POST", c.docPath(obj)+"/_search", c.searchParams(""), body, &result); err != nil {
+		return nil, err
+	}
+	return result.Aggregations, nil
+}
+
+// SearchFields runs a search query returning only the included fields of the
+// documents, without the excluded ones, both lists accepting wildcards
+func (c *ElasticSearchClient) SearchFields(obj string, query string, includes []string, excludes []string) (elastigo.SearchResult, error) {
+	source := make(map[string][]string)
+	if len(includes) > 0 {
+		source["includes"] = includes
+	}
+	if len(excludes) > 0 {
+		source["excludes"] = excludes
+	}
+
+	body, err := mergeQuery(query, map[string]interface{}{"_source": source})
+	if err != nil {
+		return elastigo.SearchResult{}, err
+	}
+
+	return c.Search(obj, body)
+}
+
+// SearchHighlight runs a search query returning, in the Highlight of each
+// hit, the fragments of the given fields matching the query
+func (c *ElasticSearchClient) SearchHighlight(obj string, query string, fields []string) (elastigo.SearchResult, error) {
+	if len(fields) == 0 {
+		return elastigo.SearchResult{}, errors.New("No field to highlight")
+	}
+
+	highlighted := make(map[string]interface{})
+	for _, field := range fields {
+		highlighted[field] = map[string]interface{}{}
+	}
+
+	body, err := mergeQuery(query, map[string]interface{}{
+		"highlight": map[string]interface{}{"fields": highlighted},
+	})
+	if err != nil {
+		return elastigo.SearchResult{}, err
+	}
+
+	return c.Search(obj, body)
+}
+
+// UnmarshalHits decodes the sources of the hits of a search result into out,
+// a pointer to a slice of the document type
+func UnmarshalHits(result elastigo.SearchResult, out interface{}) error {
+	if v := reflect.ValueOf(out); v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("Unable to unmarshal the hits into %T, a pointer to a slice is expected", out)
+	}
+
+	// the sources are decoded at once as a JSON array
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, hit := range result.Hits.Hits {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if hit.Source == nil {
+			buf.WriteString("null")
+		} else {
+			buf.Write(*hit.Source)
+		}
+	}
+	buf.WriteByte(']')
+
+	return json.Unmarshal(buf.Bytes(), out)
+}
+
+// TrackAllHits counts all the hits matching a search
+const TrackAllHits = -1
+
+// SearchOptions holds the optional parameters of a search
+type SearchOptions struct {
+	// TrackTotalHits is the number of hits counted by Elasticsearch 7 before
+	// the total becomes a lower bound, TrackAllHits for an exact total. The
+	// cluster default, 10000, is kept when 0 as counting all the hits is
+	// slower.
+	TrackTotalHits int
+	// MinScore drops the hits with a lower score when set, a pointer for a
+	// minimum score of 0 to be told apart from no minimum score
+	MinScore *float64
+}
+
+// query adds the options to a search query body
+func (o SearchOptions) query(c *ElasticSearchClient, query string) (string, error) {
+	params := make(map[string]interface{})
+
+	// the previous versions always count all the hits
+	if o.TrackTotalHits != 0 && c.version >= 7 {
+		var track interface{} = o.TrackTotalHits
+		if o.TrackTotalHits == TrackAllHits {
+			track = true
+		}
+		params["track_total_hits"] = track
+	}
+	if o.MinScore != nil {
+		params["min_score"] = *o.MinScore
+	}
+
+	if len(params) == 0 {
+		return query, nil
+	}
+	return mergeQuery(query, params)
+}
+
+// SearchWithOptions runs a search query with the given options
+func (c *ElasticSearchClient) SearchWithOptions(obj string, query string, opts SearchOptions) (elastigo.SearchResult, error) {
+	body, err := opts.query(c, query)
+	if err != nil {
+		return elastigo.SearchResult{}, err
+	}
+	return c.Search(obj, body)
+}
+
+// SearchPaged runs a search query returning size results starting at from
+func (c *ElasticSearchClient) SearchPaged(obj string, query string, from int, size int) (elastigo.SearchResult, error) {
+	return c.SearchPagedWithOptions(obj, query, from, size, SearchOptions{})
+}
+
+// SearchPagedWithOptions runs a paged search query with the given options
+func (c *ElasticSearchClient) SearchPagedWithOptions(obj string, query string, from int, size int, opts SearchOptions) (elastigo.SearchResult, error) {
+	if from < 0 || size < 0 {
+		return elastigo.SearchResult{}, fmt.Errorf("Invalid page, from %d and size %d have to be positive", from, size)
+	}
+
+	if from+size > maxResultWindow {
+		return elastigo.SearchResult{}, fmt.Errorf("%w: from %d + size %d is over %d", ErrResultWindowExceeded, from, size, maxResultWindow)
+	}
+
+	body, err := mergeQuery(query, map[string]interface{}{"from": from, "size": size})
+	if err != nil {
+		return elastigo.SearchResult{}, err
+	}
+
+	return c.SearchWithOptions(obj, body, opts)
+}
+
+// SearchWithTotal returns a page of size results starting at from along with
+// the exact number of documents matching the query, counted by the same
+// request instead of a separate count request
+func (c *ElasticSearchClient) SearchWithTotal(obj string, query string, from int, size int) (elastigo.SearchResult, int64, error) {
+	result, err := c.SearchPagedWithOptions(obj, query, from, size, SearchOptions{TrackTotalHits: TrackAllHits})
+	if err != nil {
+		return result, 0, err
+	}
+
+	total, _, err := TotalHits(result)
+	if err != nil {
+		return result, 0, err
+	}
+	return result, total, nil
+}
+
+// SearchAfter returns a page of size results of a sorted query, starting
+// after the document whose sort values are given, nil for the first page.
+// The sort values of the last hit are returned to request the next page, nil
+// once all the results were returned. The sort has to end with a unique
+// field for the pages not to skip documents having the same sort values.
+func (c *ElasticSearchClient) SearchAfter(obj string, query string, after []interface{}, size int) (elastigo.SearchResult, []interface{}, error) {
+	var request map[string]interface{}
+	if err := json.Unmarshal([]byte(query), &request); err != nil {
+		return elastigo.SearchResult{}, nil, fmt.Errorf("Unable to parse query: %s", err.Error())
+	}
+	if _, found := request["sort"]; !found {
+		return elastigo.SearchResult{}, nil, errors.New("A sort is required to search after a document")
+	}
+	if size <= 0 {
+		return elastigo.SearchResult{}, nil, fmt.Errorf("Invalid page size %d", size)
+	}
+
+	fields := map[string]interface{}{"size": size}
+	if len(after) > 0 {
+		fields["search_after"] = after
+	}
+
+	body, err := mergeQuery(query, fields)
+	if err != nil {
+		return elastigo.SearchResult{}, nil, err
+	}
+
+	result, err := c.Search(obj, body)
+	if err != nil {
+		return elastigo.SearchResult{}, nil, err
+	}
+
+	hits := result.Hits.Hits
+	if len(hits) < size {
+		return result, nil, nil
+	}
+	return result, hits[len(hits)-1].Sort, nil
+}
+
+// Count returns the number of documents matching the query, an empty query
+// counts all the documents
+func (c *ElasticSearchClient) Count(obj string, query string) (int64, error) {
+	var result struct {
+		Count int64 `json:"count"`
+	}
+
+	query, err := c.typedQuery(obj, query)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := c.jsonRequest("POST", c.docPath(obj)+"/_count", "", query, &result); err != nil {
+		return 0, err
+	}
+	return result.Count, nil
 }
 
-func (c *ElasticSearchClient) Start(mappings []map[string][]byte) {
+// Refresh makes the documents indexed so far searchable
+func (c *ElasticSearchClient) Refresh() error {
+	return c.jsonRequest("POST", "/"+c.indexPattern()+"/_refresh", "", "", nil)
+}
+
+// validateMappings checks that the mappings are valid JSON objects
+func validateMappings(mappings []map[string][]byte) error {
+	for _, document := range mappings {
+		for obj, mapping := range document {
+			var m map[string]interface{}
+			if err := json.Unmarshal(mapping, &m); err != nil {
+				return fmt.Errorf("Invalid %s mapping: %s", obj, err.Error())
+			}
+			if _, err := parseDynamic(m["dynamic"]); err != nil {
+				return fmt.Errorf("Invalid %s mapping: %s", obj, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// Start creates the index and the mappings, retrying with an exponential
+// backoff until it succeeds or until the connect timeout, if any, expires
+func (c *ElasticSearchClient) Start(mappings []map[string][]byte) error {
+	// no need to retry with invalid mappings
+	if err := validateMappings(mappings); err != nil {
+		return err
+	}
+
+	if c.indexPerType && (c.useTemplate || c.rolling) {
+		return fmt.Errorf("%w: per type indices can't be used along with an index template or rolling indices", ErrIncompatibleOptions)
+	}
+
+	// the parent and the children have to be stored in the same index
+	if c.indexPerType && len(parentTypes(mappings)) > 0 {
+		return fmt.Errorf("%w: per type indices can't hold parent/child mappings", ErrIncompatibleOptions)
+	}
+
+	var elapsed time.Duration
+
+	delay := time.Second
 	for {
+		if c.stopping.Load() == true {
+			return ErrStopped
+		}
+
+		attempt := time.Now()
 		err := c.start(mappings)
 		if err == nil {
-			break
+			// the client may have been stopped while starting
+			if c.stopping.Load() == true {
+				c.Stop()
+				return ErrStopped
+			}
+			return nil
 		}
+		elapsed += time.Since(attempt)
+
+		// retrying won't solve a conflict with the existing indices
+		if errors.Is(err, ErrMappingConflict) || errors.Is(err, ErrNewerIndexExists) {
+			return err
+		}
+
 		logging.GetLogger().Errorf("Unable to get connected to Elasticsearch: %s", err.Error())
 
-		time.Sleep(1 * time.Second)
+		if c.connectTimeout > 0 && elapsed+delay > c.connectTimeout {
+			return fmt.Errorf("Unable to get connected to Elasticsearch after %s: %s", elapsed, err.Error())
+		}
+
+		retrySleep(delay)
+		elapsed += delay
+
+		if delay *= 2; delay > c.maxRetryDelay {
+			delay = c.maxRetryDelay
+		}
 	}
 }
 
+// Stop stops the client and aborts a pending Start, only the first call
+// releases the resources
 func (c *ElasticSearchClient) Stop() {
-	if c.started.Load() == true {
-		c.indexer.Stop()
+	c.stopping.Store(true)
+	c.stopOnce.Do(func() { close(c.stopped) })
+
+	if c.started.CompareAndSwap(true, false) {
+		// the indexer is left stopping in the background when the buffered
+		// documents can't be sent in time
+		if indexer := c.bulkIndexer(); c.drain() {
+			indexer.Stop()
+		} else {
+			go indexer.Stop()
+		}
+		if c.pending != nil {
+			c.pending.close()
+		}
+		close(c.quit)
+		c.wg.Wait()
 		c.connection.Close()
 	}
 }
@@ -281,35 +1721,362 @@ func (c *ElasticSearchClient) Started() bool {
 	return c.started.Load() == true
 }
 
-func NewElasticSearchClient(addr string, port string, maxConns int, retrySeconds int, bulkMaxDocs int) (*ElasticSearchClient, error) {
-	c := elastigo.NewConn()
+// SetIndex sets the prefix used for both the alias and the versioned index
+// name, a version lower or equal to 0 selects the current index version
+func (c *ElasticSearchClient) SetIndex(prefix string, version int) {
+	if version <= 0 {
+		version = indexVersion
+	}
 
-	c.Domain = addr
-	c.Port = port
+	c.alias = prefix
+	c.index = fmt.Sprintf("%s_v%d", prefix, version)
+}
+
+// IndexName returns the name of the versioned index holding the documents
+func (c *ElasticSearchClient) IndexName() string {
+	return c.index
+}
+
+// AliasName returns the name of the alias used to access the documents
+func (c *ElasticSearchClient) AliasName() string {
+	return c.alias
+}
+
+// SetAllowScripts allows the script filters, they are replaced by a query
+// matching no document otherwise
+func (c *ElasticSearchClient) SetAllowScripts(allow bool) {
+	c.allowScripts = allow
+}
+
+// SetKeywordFields sets the text fields having a keyword sub-field, the term
+// filters on them being rewritten to match the sub-field. The fields are
+// given with their prefix, for instance Metadata/Name
+func (c *ElasticSearchClient) SetKeywordFields(fields ...string) {
+	c.keywords = make(map[string]bool, len(fields))
+	for _, field := range fields {
+		c.keywords[field] = true
+	}
+}
+
+// SetIndexSettings sets the number of shards and replicas of the index when
+// it gets created, a negative or zero number of shards and a negative number
+// of replicas keep the cluster defaults
+func (c *ElasticSearchClient) SetIndexSettings(shards int, replicas int) {
+	c.shards = shards
+	c.replicas = replicas
+}
+
+// indexSettings returns the body of the index creation request
+func (c *ElasticSearchClient) indexSettings() string {
+	settings := c.settings()
+	if len(settings) == 0 {
+		return ""
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{"settings": settings})
+	return string(body)
+}
+
+// settings returns the configured index settings
+func (c *ElasticSearchClient) settings() map[string]interface{} {
+	settings := make(map[string]interface{})
+	if c.shards > 0 {
+		settings["number_of_shards"] = c.shards
+	}
+	if c.replicas >= 0 {
+		settings["number_of_replicas"] = c.replicas
+	}
+	if c.codec != "" && c.codec != "default" {
+		settings["codec"] = c.codec
+	}
+	if len(c.analysis) > 0 {
+		settings["analysis"] = c.analysis
+	}
+	return settings
+}
+
+// SetAnalysis sets the analysis settings of the index when it gets created,
+// a JSON object declaring the custom analyzers, tokenizers and filters
+// referenced by the mappings. The settings of an existing index are left
+// untouched.
+func (c *ElasticSearchClient) SetAnalysis(analysis string) error {
+	if analysis == "" {
+		c.analysis = nil
+		return nil
+	}
+
+	var settings map[string]interface{}
+	if err := json.Unmarshal([]byte(analysis), &settings); err != nil {
+		return fmt.Errorf("Invalid analysis settings: %s", err)
+	}
+
+	c.analysis = json.RawMessage(analysis)
+	return nil
+}
+
+// SetCodec sets the compression codec of the index when it gets created,
+// best_compression trading some indexing speed for less disk usage
+func (c *ElasticSearchClient) SetCodec(codec string) error {
+	if codec != "" && codec != "default" && codec != "best_compression" {
+		return fmt.Errorf("Invalid index codec %s, has to be default or best_compression", codec)
+	}
+
+	c.codec = codec
+	return nil
+}
+
+// SetRequestRetry sets the maximum number of attempts of the document
+// requests and the time after which a failed request is not retried
+func (c *ElasticSearchClient) SetRequestRetry(maxAttempts int, timeout time.Duration) {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	c.maxAttempts = maxAttempts
+	c.retryTimeout = timeout
+}
+
+// SetRequestTimeout sets the time after which a request is aborted, 0
+// meaning no timeout. A deadline set on the context of a request overrides it.
+func (c *ElasticSearchClient) SetRequestTimeout(timeout time.Duration) {
+	c.requestTimeout = timeout
+}
+
+// SetOpaqueID enables the X-Opaque-Id header, made of the given prefix and
+// a sequence number, for the requests to be tracked in the Elasticsearch
+// logs. An empty prefix disables it.
+func (c *ElasticSearchClient) SetOpaqueID(prefix string) {
+	c.opaqueIDPrefix = prefix
+}
+
+// SetStartRetry sets the maximum delay between two attempts to start the
+// client and the time after which Start gives up, 0 meaning retrying forever
+func (c *ElasticSearchClient) SetStartRetry(maxDelay time.Duration, connectTimeout time.Duration) {
+	if maxDelay < time.Second {
+		maxDelay = time.Second
+	}
+
+	c.maxRetryDelay = maxDelay
+	c.connectTimeout = connectTimeout
+}
+
+// SetCredentials sets the credentials used for HTTP basic authentication
+func (c *ElasticSearchClient) SetCredentials(username string, password string) {
+	c.connection.Username = username
+	c.connection.Password = password
+}
+
+// SetHTTPClient sets the HTTP client used to send the requests, allowing to
+// tune its transport. It replaces the client set by EnableTLS, the TLS
+// configuration and the connection limits have then to be part of the given
+// client transport.
+func (c *ElasticSearchClient) SetHTTPClient(httpClient *http.Client) {
+	c.httpClient = httpClient
+}
+
+// EnableTLS makes the client use HTTPS with the given TLS configuration
+func (c *ElasticSearchClient) EnableTLS(tlsConfig *tls.Config) {
+	c.connection.Protocol = "https"
+	c.transport.TLSClientConfig = tlsConfig
+}
+
+// SetCompressRequests enables the gzip compression of the request bodies,
+// bulk requests included, which saves bandwidth at the cost of CPU
+func (c *ElasticSearchClient) SetCompressRequests(compress bool) {
+	c.compress = compress
+}
+
+// SetConnectionLimits sets the maximum number of idle connections kept for
+// reuse, and the maximum number of connections to a host, 0 meaning no
+// limit. Keeping enough idle connections avoids opening a new socket for
+// most of the requests under a concurrent load.
+func (c *ElasticSearchClient) SetConnectionLimits(maxIdleConns int, maxConnsPerHost int) {
+	c.transport.MaxIdleConns = maxIdleConns
+	c.transport.MaxIdleConnsPerHost = maxIdleConns
+	if maxConnsPerHost > 0 && maxConnsPerHost < maxIdleConns {
+		c.transport.MaxIdleConnsPerHost = maxConnsPerHost
+	}
+	c.transport.MaxConnsPerHost = maxConnsPerHost
+}
+
+// NewTLSConfig returns a TLS configuration trusting the given CA certificate
+// and presenting the given client certificate, all the files being optional
+func NewTLSConfig(caCert string, clientCert string, clientKey string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if caCert != "" {
+		data, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read CA certificate %s: %s", caCert, err.Error())
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("Unable to parse CA certificate %s", caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if clientCert != "" || clientKey != "" {
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load client certificate: %s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// ParseHosts parses a comma separated list of addr:port Elasticsearch hosts,
+// IPv6 addresses being enclosed in square brackets
+func ParseHosts(hosts string) ([]string, error) {
+	var result []string
+	for _, host := range strings.Split(hosts, ",") {
+		host = strings.TrimSpace(host)
+		if addr, port, err := net.SplitHostPort(host); err != nil || addr == "" || port == "" {
+			return nil, ErrBadConfig
+		}
+		result = append(result, host)
+	}
+	return result, nil
+}
+
+// NewElasticSearchClient creates a client, the bulk indexer settings keep the
+// elastigo defaults when zero. A bulk request is sent as soon as bulkMaxDocs
+// documents or bulkMaxBytes bytes are pending, whichever comes first.
+func NewElasticSearchClient(hosts []string, maxConns int, retrySeconds int, bulkMaxDocs int, bulkMaxBytes int, bulkFlushInterval time.Duration) (*ElasticSearchClient, error) {
+	if len(hosts) == 0 {
+		return nil, ErrBadConfig
+	}
+
+	c := elastigo.NewConn()
+	c.SetHosts(hosts)
 
 	indexer := c.NewBulkIndexerErrors(maxConns, retrySeconds)
-	if bulkMaxDocs <= 0 {
+	if bulkMaxDocs > 0 {
 		indexer.BulkMaxDocs = bulkMaxDocs
 	}
+	if bulkMaxBytes > 0 {
+		indexer.BulkMaxBuffer = bulkMaxBytes
+	}
+	if bulkFlushInterval > 0 {
+		indexer.BufferDelayMax = bulkFlushInterval
+	}
 
 	client := &ElasticSearchClient{
-		connection: c,
-		indexer:    indexer,
+		connection:   c,
+		indexer:      indexer,
+		bulkMaxConns: maxConns,
+		hosts:        newHostPool(hosts),
+		stopped:      make(chan struct{}),
+		transport:    http.DefaultTransport.(*http.Transport).Clone(),
+		metrics:      noopMetricsHandler{},
+		bulkErrors:   make(chan error, 100),
+		replicas:     -1,
+
+		healthStatus:  "yellow",
+		healthTimeout: 30 * time.Second,
+
+		maxRetryDelay: 30 * time.Second,
+
+		maxAttempts:  3,
+		retryTimeout: time.Duration(retrySeconds) * time.Second,
+
+		bulkMaxFailures: 5,
+		drainTimeout:    5 * time.Second,
+		bulkMaxRetries:  3,
+		bulkRetryDelay:  time.Second,
+		pendingPolicy:   PendingBlock,
 	}
+	client.SetIndex(defaultIndexPrefix, indexVersion)
+	client.httpClient = &http.Client{Transport: client.transport}
+	client.retryCond = sync.NewCond(&client.retryLock)
+
+	// bulk requests go through the same host selection as the other requests
+	indexer.Sender = client.sendBulk
 
 	client.started.Store(false)
+	client.stopping.Store(false)
 	return client, nil
 }
 
+// NewElasticSearchClientFromConfig creates a client from the
+// storage.elasticsearch section of the configuration
 func NewElasticSearchClientFromConfig() (*ElasticSearchClient, error) {
-	elasticonfig := strings.Split(config.GetConfig().GetString("storage.elasticsearch.host"), ":")
-	if len(elasticonfig) != 2 {
-		return nil, ErrBadConfig
+	hosts, err := ParseHosts(config.GetConfig().GetString("storage.elasticsearch.host"))
+	if err != nil {
+		return nil, err
+	}
+
+	bulkMaxBytes := config.GetConfig().GetInt("storage.elasticsearch.bulk_maxbytes")
+	if bulkMaxBytes == 0 {
+		// former name of the setting
+		bulkMaxBytes = config.GetConfig().GetInt("storage.elasticsearch.bulk_maxbuffer")
 	}
 
-	maxConns := config.GetConfig().GetInt("storage.elasticsearch.maxconns")
-	retrySeconds := config.GetConfig().GetInt("storage.elasticsearch.retry")
-	bulkMaxDocs := config.GetConfig().GetInt("storage.elasticsearch.bulk_maxdocs")
+	seconds := func(key string) time.Duration {
+		return time.Duration(config.GetConfig().GetInt(key)) * time.Second
+	}
+
+	opts := Options{
+		Hosts:        hosts,
+		IndexPrefix:  config.GetConfig().GetString("storage.elasticsearch.index_prefix"),
+		IndexVersion: config.GetConfig().GetInt("storage.elasticsearch.index_version"),
+		Version:      config.GetConfig().GetInt("storage.elasticsearch.version"),
+
+		Username: config.GetConfig().GetString("storage.elasticsearch.username"),
+		Password: config.GetConfig().GetString("storage.elasticsearch.password"),
+
+		MaxConns:         config.GetConfig().GetInt("storage.elasticsearch.maxconns"),
+		MaxIdleConns:     config.GetConfig().GetInt("storage.elasticsearch.max_idle_conns"),
+		MaxConnsPerHost:  config.GetConfig().GetInt("storage.elasticsearch.max_conns_per_host"),
+		CompressRequests: config.GetConfig().GetBool("storage.elasticsearch.compress_requests"),
+		MaxAttempts:      config.GetConfig().GetInt("storage.elasticsearch.max_attempts"),
+		RetryTimeout:     seconds("storage.elasticsearch.retry"),
+		RequestTimeout:   seconds("storage.elasticsearch.request_timeout"),
+		OpaqueID:         config.GetConfig().GetString("storage.elasticsearch.opaque_id"),
+		MaxRetryDelay:    seconds("storage.elasticsearch.max_retry_delay"),
+		ConnectTimeout:   seconds("storage.elasticsearch.connect_timeout"),
+		HealthStatus:     config.GetConfig().GetString("storage.elasticsearch.health_status"),
+		HealthTimeout:    seconds("storage.elasticsearch.health_timeout"),
+
+		BulkMaxDocs:       config.GetConfig().GetInt("storage.elasticsearch.bulk_maxdocs"),
+		BulkMaxBytes:      bulkMaxBytes,
+		BulkFlushInterval: config.GetConfig().GetDuration("storage.elasticsearch.bulk_flush_interval"),
+		BulkMaxFailures:   config.GetConfig().GetInt("storage.elasticsearch.bulk_max_failures"),
+		BulkMaxRetries:    config.GetConfig().GetInt("storage.elasticsearch.bulk_max_retries"),
+		BulkRetryDelay:    seconds("storage.elasticsearch.bulk_retry_delay"),
+		DrainTimeout:      seconds("storage.elasticsearch.drain_timeout"),
+		MaxPending:        config.GetConfig().GetInt("storage.elasticsearch.max_pending"),
+		PendingPolicy:     config.GetConfig().GetString("storage.elasticsearch.pending_policy"),
+		BreakerThreshold:  config.GetConfig().GetInt("storage.elasticsearch.breaker_threshold"),
+		BreakerWindow:     seconds("storage.elasticsearch.breaker_window"),
+		BreakerCooldown:   seconds("storage.elasticsearch.breaker_cooldown"),
+
+		Shards:        config.GetConfig().GetInt("storage.elasticsearch.shards"),
+		Replicas:      config.GetConfig().GetInt("storage.elasticsearch.replicas"),
+		Codec:         config.GetConfig().GetString("storage.elasticsearch.codec"),
+		Analysis:      config.GetConfig().GetString("storage.elasticsearch.analysis"),
+		AllowScripts:  config.GetConfig().GetBool("storage.elasticsearch.allow_scripts"),
+		KeywordFields: config.GetConfig().GetStringSlice("storage.elasticsearch.keyword_fields"),
+		IndexTemplate: config.GetConfig().GetBool("storage.elasticsearch.index_template"),
+		RollingIndex:  config.GetConfig().GetBool("storage.elasticsearch.rolling_index"),
+		IndexPerType:  config.GetConfig().GetBool("storage.elasticsearch.index_per_type"),
+
+		DynamicMapping: config.GetConfig().GetStringMapString("storage.elasticsearch.dynamic_mapping"),
+	}
+
+	if config.GetConfig().GetBool("storage.elasticsearch.tls.enabled") {
+		opts.TLSConfig, err = NewTLSConfig(
+			config.GetConfig().GetString("storage.elasticsearch.tls.ca_cert"),
+			config.GetConfig().GetString("storage.elasticsearch.tls.client_cert"),
+			config.GetConfig().GetString("storage.elasticsearch.tls.client_key"),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	return NewElasticSearchClient(elasticonfig[0], elasticonfig[1], maxConns, retrySeconds, bulkMaxDocs)
+	return NewElasticSearchClientFromOpts(opts)
 }
@@ -0,0 +1,2286 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	elastigo "github.com/lebauce/elastigo/lib"
+
+	"github.com/skydive-project/skydive/config"
+	"github.com/skydive-project/skydive/filters"
+)
+
+func hostOf(server *httptest.Server) string {
+	return server.Listener.Addr().String()
+}
+
+func newTestClient(t *testing.T, hosts ...string) *ElasticSearchClient {
+	client, err := NewElasticSearchClient(hosts, 10, 60, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestParseHosts(t *testing.T) {
+	hosts, err := ParseHosts("es1:9200, es2:9200,es3:9200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 3 || hosts[0] != "es1:9200" || hosts[1] != "es2:9200" || hosts[2] != "es3:9200" {
+		t.Errorf("Wrong hosts parsed: %v", hosts)
+	}
+
+	hosts, err = ParseHosts("[::1]:9200,[fe80::1]:9201")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 || hosts[0] != "[::1]:9200" || hosts[1] != "[fe80::1]:9201" {
+		t.Errorf("Wrong IPv6 hosts parsed: %v", hosts)
+	}
+
+	for _, hosts := range []string{"es1:9200,es2", "::1:9200", "es1:", ":9200"} {
+		if _, err := ParseHosts(hosts); err != ErrBadConfig {
+			t.Errorf("Expected ErrBadConfig for %s, got: %v", hosts, err)
+		}
+	}
+}
+
+func TestHostFailover(t *testing.T) {
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	dead.Close()
+
+	hits := 0
+	alive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Write([]byte(`{"hits": {"total": 1, "hits": [{"_id": "aaa"}]}}`))
+	}))
+	defer alive.Close()
+
+	client := newTestClient(t, hostOf(dead), hostOf(alive))
+	for i := 0; i < 4; i++ {
+		result, err := client.Search("node", `{"query": {"match_all": {}}}`)
+		if err != nil {
+			t.Fatalf("Search should succeed on the alive host: %s", err.Error())
+		}
+		if result.Hits.Total != 1 {
+			t.Errorf("Expected 1 hit, got %d", result.Hits.Total)
+		}
+	}
+
+	if hits != 4 {
+		t.Errorf("Expected 4 requests on the alive host, got %d", hits)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"hits": {"total": 0, "hits": []}}`))
+	}))
+	defer server.Close()
+
+	caFile, err := ioutil.TempFile("", "skydive-es-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(caFile.Name())
+
+	pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	caFile.Close()
+
+	tlsConfig, err := NewTLSConfig(caFile.Name(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(t, hostOf(server))
+	client.EnableTLS(tlsConfig)
+	if _, err := client.Search("node", ""); err != nil {
+		t.Errorf("Request should succeed with a trusted CA: %s", err.Error())
+	}
+
+	client = newTestClient(t, hostOf(server))
+	client.EnableTLS(&tls.Config{})
+	if _, err := client.Search("node", ""); err == nil {
+		t.Error("Request should fail with an untrusted CA")
+	}
+}
+
+func TestConnectionLimits(t *testing.T) {
+	client := newTestClient(t, "127.0.0.1:9200")
+	client.SetConnectionLimits(50, 10)
+
+	transport, ok := client.httpClient.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("Expected an HTTP transport, got %T", client.httpClient.Transport)
+	}
+	if transport.MaxIdleConns != 50 || transport.MaxIdleConnsPerHost != 10 || transport.MaxConnsPerHost != 10 {
+		t.Errorf("Wrong transport limits: %d idle, %d idle per host, %d per host",
+			transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.MaxConnsPerHost)
+	}
+
+	// the limits are kept when enabling TLS
+	client.EnableTLS(&tls.Config{})
+	if transport := client.httpClient.Transport.(*http.Transport); transport.MaxIdleConns != 50 || transport.TLSClientConfig == nil {
+		t.Errorf("Transport limits lost when enabling TLS")
+	}
+
+	client.SetConnectionLimits(50, 0)
+	if transport.MaxIdleConnsPerHost != 50 || transport.MaxConnsPerHost != 0 {
+		t.Errorf("Wrong transport limits without host limit: %d idle per host, %d per host",
+			transport.MaxIdleConnsPerHost, transport.MaxConnsPerHost)
+	}
+}
+
+type recordingTransport struct {
+	requests []string
+}
+
+func (r *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.requests = append(r.requests, req.Method+" "+req.URL.Path)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestHTTPClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"count": 3}`))
+	}))
+	defer server.Close()
+
+	transport := &recordingTransport{}
+
+	client := newTestClient(t, hostOf(server))
+	client.SetHTTPClient(&http.Client{Transport: transport, Timeout: 5 * time.Second})
+
+	if _, err := client.Count("node", ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(transport.requests) != 1 || transport.requests[0] != "POST /skydive/node/_count" {
+		t.Errorf("Expected the request to go through the given transport, got: %v", transport.requests)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.Write([]byte(`{"hits": {"total": 0, "hits": []}}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	client.SetCredentials("skydive", "secret")
+	if _, err := client.Search("node", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Basic " + base64.StdEncoding.EncodeToString([]byte("skydive:secret"))
+	if authorization != expected {
+		t.Errorf("Expected Authorization header %s, got %s", expected, authorization)
+	}
+}
+
+func TestBadAuthConfig(t *testing.T) {
+	config.GetConfig().Set("storage.elasticsearch.host", "127.0.0.1:9200")
+	config.GetConfig().Set("storage.elasticsearch.username", "skydive")
+	defer config.GetConfig().Set("storage.elasticsearch.username", "")
+
+	if _, err := NewElasticSearchClientFromConfig(); err != ErrBadAuthConfig {
+		t.Errorf("Expected ErrBadAuthConfig, got: %v", err)
+	}
+}
+
+type filterTest struct {
+	name     string
+	filter   *filters.Filter
+	prefix   string
+	expected string
+}
+
+func testFormatFilter(t *testing.T, client *ElasticSearchClient, tests []filterTest) {
+	for _, test := range tests {
+		data, err := json.Marshal(client.FormatFilter(test.filter, test.prefix))
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err.Error())
+		}
+
+		var expected, got interface{}
+		if err := json.Unmarshal([]byte(test.expected), &expected); err != nil {
+			t.Fatalf("%s: %s", test.name, err.Error())
+		}
+		json.Unmarshal(data, &got)
+
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("%s: query mismatch, expected: %s, got: %s", test.name, test.expected, string(data))
+		}
+	}
+}
+
+var errFieldNotFound = errors.New("Field not found")
+
+// mapGetter evaluates the filters in memory against the values of a map
+type mapGetter map[string]interface{}
+
+func (m mapGetter) GetFieldInt64(field string) (int64, error) {
+	if i, ok := m[field].(int64); ok {
+		return i, nil
+	}
+	return 0, errFieldNotFound
+}
+
+func (m mapGetter) GetFieldFloat64(field string) (float64, error) {
+	if f, ok := m[field].(float64); ok {
+		return f, nil
+	}
+	return 0, errFieldNotFound
+}
+
+func (m mapGetter) GetFieldString(field string) (string, error) {
+	if s, ok := m[field].(string); ok {
+		return s, nil
+	}
+	return "", errFieldNotFound
+}
+
+func (m mapGetter) GetFieldBool(field string) (bool, error) {
+	if b, ok := m[field].(bool); ok {
+		return b, nil
+	}
+	return false, errFieldNotFound
+}
+
+func TestTermBoolFilter(t *testing.T) {
+	testFormatFilter(t, newTestClient(t, "127.0.0.1:9200"), []filterTest{
+		{
+			name:     "true",
+			filter:   filters.NewTermBoolFilter("Forwarding", true),
+			prefix:   "Metadata/",
+			expected: `{"term": {"Metadata/Forwarding": true}}`,
+		},
+		{
+			name:     "false",
+			filter:   filters.NewTermBoolFilter("Forwarding", false),
+			expected: `{"term": {"Forwarding": false}}`,
+		},
+	})
+
+	// the value is a JSON boolean, not a string
+	data, _ := json.Marshal(newTestClient(t, "127.0.0.1:9200").FormatFilter(filters.NewTermBoolFilter("Forwarding", false), ""))
+	if string(data) != `{"term":{"Forwarding":false}}` {
+		t.Errorf("Expected a boolean term, got %s", string(data))
+	}
+}
+
+func TestExistsFilter(t *testing.T) {
+	testFormatFilter(t, newTestClient(t, "127.0.0.1:9200"), []filterTest{
+		{
+			name:     "exists",
+			filter:   filters.NewExistsFilter("Metric.RxBytes"),
+			expected: `{"exists": {"field": "Metric.RxBytes"}}`,
+		},
+		{
+			name:     "exists with prefix",
+			filter:   filters.NewExistsFilter("Name"),
+			prefix:   "Metadata/",
+			expected: `{"exists": {"field": "Metadata/Name"}}`,
+		},
+		{
+			name:     "not exists",
+			filter:   filters.NewNotFilter(filters.NewExistsFilter("Metric.RxBytes")),
+			expected: `{"bool": {"must_not": [{"exists": {"field": "Metric.RxBytes"}}]}}`,
+		},
+		{
+			name:     "not exists filter",
+			filter:   filters.NewNotExistsFilter("Name"),
+			prefix:   "Metadata/",
+			expected: `{"bool": {"must_not": {"exists": {"field": "Metadata/Name"}}}}`,
+		},
+	})
+
+	// all the field types exist in memory as in Elasticsearch
+	node := mapGetter{"Name": "eth0", "MTU": int64(1500), "Load": 0.5, "Forwarding": true}
+	for _, key := range []string{"Name", "MTU", "Load", "Forwarding"} {
+		if !filters.NewExistsFilter(key).Eval(node) {
+			t.Errorf("Expected the %s field to exist", key)
+		}
+		if filters.NewNotExistsFilter(key).Eval(node) {
+			t.Errorf("Expected the %s field not to be reported missing", key)
+		}
+	}
+	if filters.NewExistsFilter("Speed").Eval(node) || !filters.NewNotExistsFilter("Speed").Eval(node) {
+		t.Error("Expected the Speed field to be missing")
+	}
+}
+
+func TestPrefixWildcardFilter(t *testing.T) {
+	testFormatFilter(t, newTestClient(t, "127.0.0.1:9200"), []filterTest{
+		{
+			name:     "prefix",
+			filter:   filters.NewPrefixFilter("Name", "br-"),
+			prefix:   "Metadata/",
+			expected: `{"prefix": {"Metadata/Name": "br-"}}`,
+		},
+		{
+			name:     "wildcard",
+			filter:   filters.NewWildcardFilter("Name", "br-*"),
+			prefix:   "Metadata/",
+			expected: `{"wildcard": {"Metadata/Name": "br-*"}}`,
+		},
+	})
+}
+
+func TestCreateAliasError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": {"type": "invalid_alias_name_exception"}, "status": 400}`))
+	}))
+	defer server.Close()
+
+	err := newTestClient(t, hostOf(server)).createAlias()
+	if !errors.Is(err, ErrAliasCreation) {
+		t.Fatalf("Expected ErrAliasCreation, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "invalid_alias_name_exception") {
+		t.Errorf("Error should contain the response body, got: %s", err.Error())
+	}
+}
+
+func TestSwapAlias(t *testing.T) {
+	var posts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			if r.URL.Path != "/_alias/skydive" {
+				t.Errorf("Wrong alias request: %s", r.URL.Path)
+			}
+			w.Write([]byte(`{
+				"skydive_v3": {"aliases": {"skydive": {}}},
+				"skydive_v2": {"aliases": {"skydive": {}}}
+			}`))
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		posts = append(posts, r.URL.Path+" "+string(body))
+		w.Write([]byte(`{"acknowledged": true}`))
+	}))
+	defer server.Close()
+
+	if err := newTestClient(t, hostOf(server)).SwapAlias("skydive_v4"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `/_aliases {"actions":[` +
+		`{"remove":{"alias":"skydive","index":"skydive_v2"}},` +
+		`{"remove":{"alias":"skydive","index":"skydive_v3"}},` +
+		`{"add":{"alias":"skydive","index":"skydive_v4"}}]}`
+	if len(posts) != 1 || posts[0] != expected {
+		t.Errorf("Expected a single %s request, got %v", expected, posts)
+	}
+}
+
+func TestIndexPrefix(t *testing.T) {
+	var paths, bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		paths = append(paths, r.URL.Path)
+		bodies = append(bodies, string(body))
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	for _, prefix := range []string{"staging", "prod"} {
+		paths, bodies = nil, nil
+
+		client := newTestClient(t, hostOf(server))
+		client.SetIndex(prefix, 0)
+
+		if err := client.createAlias(); err != nil {
+			t.Fatal(err)
+		}
+		client.Search("node", "")
+
+		expected := fmt.Sprintf(`{"add":{"alias":"%s","index":"%s_v%d"}}`, prefix, prefix, indexVersion)
+		if !strings.Contains(bodies[1], expected) {
+			t.Errorf("Alias not created on the prefixed index: %s", bodies[1])
+		}
+		if paths[2] != "/"+prefix+"/node/_search" {
+			t.Errorf("Search not done on the prefixed alias: %s", paths[2])
+		}
+	}
+}
+
+func TestIndexName(t *testing.T) {
+	client := newTestClient(t, "127.0.0.1:9200")
+	if name := client.IndexName(); name != "skydive_v3" {
+		t.Errorf("Expected skydive_v3 index, got %s", name)
+	}
+	if name := client.AliasName(); name != "skydive" {
+		t.Errorf("Expected skydive alias, got %s", name)
+	}
+
+	client.SetIndex("staging", 4)
+	if client.IndexName() != "staging_v4" || client.AliasName() != "staging" {
+		t.Errorf("Expected staging_v4 index and staging alias, got %s and %s", client.IndexName(), client.AliasName())
+	}
+}
+
+func TestIndexNameConsistency(t *testing.T) {
+	server := newRecordingServer(t)
+	defer server.Close()
+	server.respond(`{"_id": "aaa", "found": true, "_source": {}, "docs": [], "count": 0}`)
+
+	client := newTestClient(t, hostOf(server.Server))
+	client.SetIndex("staging", 0)
+
+	client.Index("node", "aaa", map[string]interface{}{})
+	client.IndexChild("metric", "aaa", "bbb", map[string]interface{}{})
+	client.Update("node", "aaa", map[string]interface{}{})
+	client.Get("node", "aaa")
+	client.Delete("node", "aaa")
+	client.MultiGet("node", []string{"aaa"})
+	client.Search("node", `{"query": {"match_all": {}}}`)
+	client.Count("node", `{"query": {"match_all": {}}}`)
+	client.DeleteByQuery("node", `{"query": {"match_all": {}}}`)
+	client.Refresh()
+
+	server.Lock()
+	defer server.Unlock()
+
+	if len(server.requests) != 10 {
+		t.Fatalf("Expected 10 requests, got %d", len(server.requests))
+	}
+	for _, request := range server.requests {
+		if !strings.HasPrefix(request.path, "/staging/") && !strings.HasPrefix(request.path, "/staging_v3/") {
+			t.Errorf("Request not done on the configured index: %s %s", request.method, request.path)
+		}
+	}
+}
+
+func TestUpdateWithScript(t *testing.T) {
+	server := newRecordingServer(t)
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+
+	params := map[string]interface{}{"count": 10}
+	if err := client.UpdateWithScript("node", "aaa", "ctx._source.Packets = (ctx._source.Packets ?: 0) + params.count", params); err != nil {
+		t.Fatal(err)
+	}
+
+	request := server.last(t)
+	if request.method != "POST" || request.path != "/skydive/node/aaa/_update" || request.query != "retry_on_conflict=3" {
+		t.Errorf("Wrong update request: %s %s?%s", request.method, request.path, request.query)
+	}
+
+	expected := map[string]interface{}{
+		"script": map[string]interface{}{
+			"inline": "ctx._source.Packets = (ctx._source.Packets ?: 0) + params.count",
+			"lang":   "painless",
+			"params": map[string]interface{}{"count": float64(10)},
+		},
+		"scripted_upsert": true,
+		"upsert":          map[string]interface{}{},
+	}
+	if !reflect.DeepEqual(request.body, expected) {
+		t.Errorf("Expected %v update, got %v", expected, request.body)
+	}
+
+	// the document created by the upsert has to be typed in single type mode
+	client.SetVersion(6)
+	if err := client.UpdateWithScript("node", "aaa", "ctx._source.Packets += 1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	request = server.last(t)
+	if request.path != "/skydive/_doc/aaa/_update" {
+		t.Errorf("Wrong update request path: %s", request.path)
+	}
+	script := request.body["script"].(map[string]interface{})
+	if _, found := script["params"]; found || script["source"] != "ctx._source.Packets += 1" {
+		t.Errorf("Wrong update script: %v", script)
+	}
+	if upsert := request.body["upsert"].(map[string]interface{}); upsert[docTypeField] != "node" {
+		t.Errorf("Expected a typed upsert document, got %v", upsert)
+	}
+}
+
+func TestGetNotFound(t *testing.T) {
+	requestRetryDelay = time.Millisecond
+	defer func() { requestRetryDelay = 100 * time.Millisecond }()
+
+	code, response := http.StatusOK, `{"_id": "aaa", "found": true, "_source": {}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+
+	if resp, err := client.Get("node", "aaa"); err != nil || !resp.Found {
+		t.Errorf("Expected the document to be found, got %v", err)
+	}
+	if exists, err := client.Exists("node", "aaa"); err != nil || !exists {
+		t.Errorf("Expected the document to exist, got %v", err)
+	}
+
+	code, response = http.StatusNotFound, `{"_id": "aaa", "found": false}`
+	if _, err := client.Get("node", "aaa"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a not found error, got %v", err)
+	}
+	if exists, err := client.Exists("node", "aaa"); err != nil || exists {
+		t.Errorf("Expected the document to not exist, got %v", err)
+	}
+
+	code, response = http.StatusServiceUnavailable, `{"error": "unavailable"}`
+	if _, err := client.Get("node", "aaa"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a server error, got %v", err)
+	}
+	if _, err := client.Exists("node", "aaa"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a server error, got %v", err)
+	}
+}
+
+func TestGetInto(t *testing.T) {
+	requestRetryDelay = time.Millisecond
+	defer func() { requestRetryDelay = 100 * time.Millisecond }()
+
+	code, response := http.StatusOK, `{"_id": "aaa", "found": true, "_source": {"Name": "eth0", "MTU": 1500}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+
+	var node struct {
+		Name string
+		MTU  int64
+	}
+	if found, err := client.GetInto("node", "aaa", &node); err != nil || !found {
+		t.Fatalf("Expected the document to be found, got %v", err)
+	}
+	if node.Name != "eth0" || node.MTU != 1500 {
+		t.Errorf("Expected the source to be decoded, got %+v", node)
+	}
+
+	code, response = http.StatusNotFound, `{"_id": "aaa", "found": false}`
+	if found, err := client.GetInto("node", "aaa", &node); err != nil || found {
+		t.Errorf("Expected the document to not be found, got %v", err)
+	}
+
+	code, response = http.StatusServiceUnavailable, `{"error": "unavailable"}`
+	if found, err := client.GetInto("node", "aaa", &node); err == nil || found {
+		t.Errorf("Expected a server error, got %v", err)
+	}
+
+	code, response = http.StatusOK, `{"_id": "aaa", "found": true, "_source": {"MTU": "large"}}`
+	if _, err := client.GetInto("node", "aaa", &node); err == nil {
+		t.Error("Expected an error decoding the source")
+	}
+}
+
+func TestOpaqueID(t *testing.T) {
+	var ids []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids = append(ids, r.Header.Get("X-Opaque-Id"))
+		w.Write([]byte(`{"hits": {"total": 0, "hits": []}}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	for i := 0; i < 2; i++ {
+		if _, err := client.Search("node", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client.SetOpaqueID("analyzer1")
+	for i := 0; i < 2; i++ {
+		if _, err := client.Search("node", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expected := []string{"", "", "analyzer1-1", "analyzer1-2"}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("Expected the opaque ids %v, got %v", expected, ids)
+	}
+
+	// the failed requests report their id
+	server.Close()
+	_, err := client.Search("node", "")
+	if err == nil || !strings.Contains(err.Error(), "X-Opaque-Id analyzer1-3") {
+		t.Errorf("Expected the error to hold the opaque id, got %v", err)
+	}
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		t.Errorf("Expected the transport error to be wrapped, got %v", err)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	done := make(chan struct{})
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	client := newTestClient(t, hostOf(server))
+	client.SetRequestTimeout(100 * time.Millisecond)
+
+	start := time.Now()
+	_, err := client.Search("node", `{"query": {"match_all": {}}}`)
+	if !errors.Is(err, ErrRequestTimeout) {
+		t.Errorf("Expected a timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Request not aborted after the timeout, took %s", elapsed)
+	}
+
+	// a slow request is not retried
+	start = time.Now()
+	if _, err := client.Get("node", "aaa"); !errors.Is(err, ErrRequestTimeout) {
+		t.Errorf("Expected a timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Request not aborted after the timeout, took %s", elapsed)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("Expected 2 requests, got %d", n)
+	}
+
+	// the deadline of the context overrides the default timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	start = time.Now()
+	if _, err := client.SearchWithContext(ctx, "node", `{"query": {"match_all": {}}}`); err != context.DeadlineExceeded {
+		t.Errorf("Expected the context deadline to be exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("Request aborted before the context deadline, took %s", elapsed)
+	}
+}
+
+func TestSearchAfter(t *testing.T) {
+	server := newRecordingServer(t)
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+	query := `{"query": {"match_all": {}}, "sort": [{"Last": "asc"}, {"UUID": "asc"}]}`
+
+	server.respond(`{"hits": {"total": 3, "hits": [
+		{"_id": "aaa", "sort": [1000, "aaa"]},
+		{"_id": "bbb", "sort": [2000, "bbb"]}
+	]}}`)
+	result, after, err := client.SearchAfter("flow", query, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Hits.Hits) != 2 || !reflect.DeepEqual(after, []interface{}{float64(2000), "bbb"}) {
+		t.Errorf("Wrong first page %v with cursor %v", result.Hits.Hits, after)
+	}
+
+	request := server.last(t)
+	if _, found := request.body["search_after"]; found || request.body["size"] != float64(2) {
+		t.Errorf("Wrong first page request: %v", request.body)
+	}
+
+	server.respond(`{"hits": {"total": 3, "hits": [
+		{"_id": "ccc", "sort": [3000, "ccc"]}
+	]}}`)
+	result, after, err = client.SearchAfter("flow", query, after, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Hits.Hits) != 1 || result.Hits.Hits[0].Id != "ccc" || after != nil {
+		t.Errorf("Wrong last page %v with cursor %v", result.Hits.Hits, after)
+	}
+
+	request = server.last(t)
+	if !reflect.DeepEqual(request.body["search_after"], []interface{}{float64(2000), "bbb"}) {
+		t.Errorf("Cursor not sent with the second page request: %v", request.body)
+	}
+
+	if _, _, err := client.SearchAfter("flow", `{"query": {"match_all": {}}}`, nil, 2); err == nil {
+		t.Error("Expected an error without sort")
+	}
+}
+
+func TestDo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/_cat/indices" && r.URL.RawQuery == "format=json":
+			w.Write([]byte(`[{"index": "skydive_v3", "health": "green"}]`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "unknown endpoint"}`))
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+
+	code, data, err := client.Do("GET", "/_cat/indices", "format=json", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusOK || string(data) != `[{"index": "skydive_v3", "health": "green"}]` {
+		t.Errorf("Wrong response %d: %s", code, string(data))
+	}
+
+	// the status code is left to the caller
+	code, _, err = client.Do("POST", "/_unknown", "", `{}`)
+	if err != nil || code != http.StatusBadRequest {
+		t.Errorf("Expected a 400 status code without error, got %d: %v", code, err)
+	}
+}
+
+func TestCompressRequests(t *testing.T) {
+	var encoding, contentType, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding, contentType = r.Header.Get("Content-Encoding"), r.Header.Get("Content-Type")
+
+		reader := io.Reader(r.Body)
+		if encoding == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("Invalid gzip body: %s", err.Error())
+				return
+			}
+			reader = gz
+		}
+		data, _ := ioutil.ReadAll(reader)
+		body = string(data)
+
+		w.Write([]byte(`{"hits": {"total": 0, "hits": []}}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	client.SetCompressRequests(true)
+
+	query := `{"query":{"term":{"Type":"netns"}}}`
+	if _, err := client.Search("node", query); err != nil {
+		t.Fatal(err)
+	}
+	if encoding != "gzip" || contentType != "application/json" {
+		t.Errorf("Wrong headers, encoding %s and content type %s", encoding, contentType)
+	}
+	if body != query {
+		t.Errorf("Expected %s body once decompressed, got %s", query, body)
+	}
+
+	// requests without body are left untouched
+	if _, _, err := client.Do("GET", "/_cluster/health", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if encoding != "" || body != "" {
+		t.Errorf("No encoding expected without body, got %s", encoding)
+	}
+}
+
+func TestSearchHighlight(t *testing.T) {
+	server := newRecordingServer(t)
+	defer server.Close()
+	server.respond(`{"hits": {"total": 1, "hits": [{
+		"_id": "aaa",
+		"_source": {},
+		"highlight": {"Description": ["the <em>bridge</em> of the host", "another <em>bridge</em>"]}
+	}]}}`)
+
+	client := newTestClient(t, hostOf(server.Server))
+	result, err := client.SearchHighlight("node", `{"query": {"match": {"Description": "bridge"}}}`, []string{"Description", "Metadata.Name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"fields": map[string]interface{}{
+			"Description":   map[string]interface{}{},
+			"Metadata.Name": map[string]interface{}{},
+		},
+	}
+	if request := server.last(t); !reflect.DeepEqual(request.body["highlight"], expected) {
+		t.Errorf("Expected %v highlight, got %v", expected, request.body["highlight"])
+	}
+
+	if len(result.Hits.Hits) != 1 || result.Hits.Hits[0].Highlight == nil {
+		t.Fatalf("Expected a highlighted hit, got %v", result.Hits.Hits)
+	}
+	fragments := (*result.Hits.Hits[0].Highlight)["Description"]
+	if !reflect.DeepEqual(fragments, []string{"the <em>bridge</em> of the host", "another <em>bridge</em>"}) {
+		t.Errorf("Wrong highlighted fragments: %v", fragments)
+	}
+
+	if _, err := client.SearchHighlight("node", `{}`, nil); err == nil {
+		t.Error("Expected an error without field")
+	}
+}
+
+func TestSearchCancel(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := newTestClient(t, hostOf(server)).SearchWithContext(ctx, "node", "")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a context error, got: %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Search should return promptly once cancelled, took %s", time.Since(start))
+	}
+}
+
+func TestCount(t *testing.T) {
+	var path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		path, body = r.URL.Path, string(data)
+		w.Write([]byte(`{"count": 42, "_shards": {"total": 5, "successful": 5, "failed": 0}}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	count, err := client.Count("flow", `{"query": {"term": {"Application": "TCP"}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 42 {
+		t.Errorf("Expected a count of 42, got %d", count)
+	}
+	if path != "/skydive/flow/_count" {
+		t.Errorf("Wrong count endpoint: %s", path)
+	}
+
+	if _, err := client.Count("flow", ""); err != nil || body != "" {
+		t.Errorf("Counting all the documents should send no query, got: %s (%v)", body, err)
+	}
+}
+
+func TestFormatSort(t *testing.T) {
+	client := newTestClient(t, "127.0.0.1:9200")
+
+	for order, expected := range map[int]string{
+		AscendingOrder:  `{"Last":{"order":"asc"}}`,
+		DescendingOrder: `{"Last":{"order":"desc"}}`,
+	} {
+		sort, err := client.FormatSort("Last", order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := json.Marshal(sort); string(data) != expected {
+			t.Errorf("Expected sort %s, got %s", expected, string(data))
+		}
+	}
+
+	if _, err := client.FormatSort("Last", 42); err != ErrBadSortOrder {
+		t.Errorf("Expected ErrBadSortOrder, got: %v", err)
+	}
+	if _, err := client.SearchSorted("flow", "", "Last", 42); err != ErrBadSortOrder {
+		t.Errorf("Expected ErrBadSortOrder, got: %v", err)
+	}
+}
+
+func TestSearchSorted(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+		w.Write([]byte(`{"hits": {"total": 0, "hits": []}}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	if _, err := client.SearchSorted("flow", `{"size": 5}`, "Start", DescendingOrder); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"size":5,"sort":[{"Start":{"order":"desc"}}]}`
+	if body != expected {
+		t.Errorf("Expected query %s, got %s", expected, body)
+	}
+}
+
+func TestFloat64RangeFilter(t *testing.T) {
+	testFormatFilter(t, newTestClient(t, "127.0.0.1:9200"), []filterTest{
+		{
+			name:     "gt",
+			filter:   filters.NewGtFloat64Filter("Ratio", 0.5),
+			expected: `{"range": {"Ratio": {"gt": 0.5}}}`,
+		},
+		{
+			name:     "lt",
+			filter:   filters.NewLtFloat64Filter("Ratio", 1.5),
+			expected: `{"range": {"Ratio": {"lt": 1.5}}}`,
+		},
+		{
+			name:     "gte zero",
+			filter:   filters.NewGteFloat64Filter("Ratio", 0.0),
+			expected: `{"range": {"Ratio": {"gte": 0}}}`,
+		},
+		{
+			name:     "lte with prefix",
+			filter:   filters.NewLteFloat64Filter("Ratio", 0.75),
+			prefix:   "Metadata/",
+			expected: `{"range": {"Metadata/Ratio": {"lte": 0.75}}}`,
+		},
+	})
+}
+
+func TestRangeFilter(t *testing.T) {
+	testFormatFilter(t, newTestClient(t, "127.0.0.1:9200"), []filterTest{
+		{
+			name: "gt only",
+			filter: &filters.Filter{RangeFilter: &filters.RangeFilter{
+				Key: "RxBytes",
+				Gt:  &filters.RangeBound{Value: 100},
+			}},
+			expected: `{"range": {"RxBytes": {"gt": 100}}}`,
+		},
+		{
+			name: "lt only",
+			filter: &filters.Filter{RangeFilter: &filters.RangeFilter{
+				Key: "RxBytes",
+				Lt:  &filters.RangeBound{Value: 1000},
+			}},
+			expected: `{"range": {"RxBytes": {"lt": 1000}}}`,
+		},
+		{
+			name: "all bounds",
+			filter: &filters.Filter{RangeFilter: &filters.RangeFilter{
+				Key: "RxBytes",
+				Gt:  &filters.RangeBound{Value: 0},
+				Gte: &filters.RangeBound{Value: 100},
+				Lt:  &filters.RangeBound{Value: 2000},
+				Lte: &filters.RangeBound{Value: 1000},
+			}},
+			prefix:   "Metric.",
+			expected: `{"range": {"Metric.RxBytes": {"gt": 0, "gte": 100, "lt": 2000, "lte": 1000}}}`,
+		},
+	})
+}
+
+func TestRequireExists(t *testing.T) {
+	dateRange, err := filters.NewDateRangeFilter("Start", "now-1d", "now")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testFormatFilter(t, newTestClient(t, "127.0.0.1:9200"), []filterTest{
+		{
+			name:     "without guard",
+			filter:   filters.NewGtInt64Filter("MTU", 1500),
+			expected: `{"range": {"MTU": {"gt": 1500}}}`,
+		},
+		{
+			name:     "int64 with guard",
+			filter:   filters.NewGtInt64Filter("MTU", 1500).RequireExists(),
+			prefix:   "Metadata/",
+			expected: `{"bool": {"must": [{"exists": {"field": "Metadata/MTU"}}, {"range": {"Metadata/MTU": {"gt": 1500}}}]}}`,
+		},
+		{
+			name:     "float64 with guard",
+			filter:   filters.NewLteFloat64Filter("Ratio", 0.5).RequireExists(),
+			expected: `{"bool": {"must": [{"exists": {"field": "Ratio"}}, {"range": {"Ratio": {"lte": 0.5}}}]}}`,
+		},
+		{
+			name: "range with guard",
+			filter: (&filters.Filter{RangeFilter: &filters.RangeFilter{
+				Key: "RxBytes",
+				Gte: &filters.RangeBound{Value: 100},
+			}}).RequireExists(),
+			expected: `{"bool": {"must": [{"exists": {"field": "RxBytes"}}, {"range": {"RxBytes": {"gte": 100}}}]}}`,
+		},
+		{
+			name:     "date range with guard",
+			filter:   dateRange.RequireExists(),
+			expected: `{"bool": {"must": [{"exists": {"field": "Start"}}, {"range": {"Start": {"gte": "now-1d", "lte": "now"}}}]}}`,
+		},
+	})
+}
+
+func TestNestedFilter(t *testing.T) {
+	testFormatFilter(t, newTestClient(t, "127.0.0.1:9200"), []filterTest{
+		{
+			name:     "term",
+			filter:   filters.NewNestedFilter("Link", filters.NewTermStringFilter("Protocol", "ETHERNET")),
+			expected: `{"nested": {"path": "Link", "query": {"term": {"Link.Protocol": "ETHERNET"}}}}`,
+		},
+		{
+			name: "bool with prefix",
+			filter: filters.NewNestedFilter("Link", filters.NewAndFilter(
+				filters.NewTermStringFilter("Protocol", "ETHERNET"),
+				filters.NewGtInt64Filter("ID", 10),
+			)),
+			prefix: "Metadata.",
+			expected: `{"nested": {"path": "Metadata.Link", "query": {"bool": {"must": [
+				{"term": {"Metadata.Link.Protocol": "ETHERNET"}},
+				{"range": {"Metadata.Link.ID": {"gt": 10}}}
+			]}}}}`,
+		},
+		{
+			name:   "nested in nested",
+			filter: filters.NewNestedFilter("Link", filters.NewNestedFilter("Layers", filters.NewExistsFilter("Name"))),
+			expected: `{"nested": {"path": "Link", "query": {"nested": {"path": "Link.Layers", "query":
+				{"exists": {"field": "Link.Layers.Name"}}}}}}`,
+		},
+	})
+}
+
+func TestTermsStringFilter(t *testing.T) {
+	testFormatFilter(t, newTestClient(t, "127.0.0.1:9200"), []filterTest{
+		{
+			name:     "values",
+			filter:   filters.NewTermsStringFilter("Type", "netns", "ovsbridge", "veth"),
+			prefix:   "Metadata/",
+			expected: `{"terms": {"Metadata/Type": ["netns", "ovsbridge", "veth"]}}`,
+		},
+		{
+			name:     "no value",
+			filter:   filters.NewTermsStringFilter("Type"),
+			expected: `{"bool": {"must_not": {"match_all": {}}}}`,
+		},
+	})
+}
+
+func TestTermsInt64Filter(t *testing.T) {
+	testFormatFilter(t, newTestClient(t, "127.0.0.1:9200"), []filterTest{
+		{
+			name:     "values",
+			filter:   filters.NewTermsInt64Filter("Transport", 6, 17),
+			prefix:   "Metric/",
+			expected: `{"terms": {"Metric/Transport": [6, 17]}}`,
+		},
+		{
+			name:     "single value",
+			filter:   filters.NewTermsInt64Filter("Transport", 6),
+			expected: `{"terms": {"Transport": [6]}}`,
+		},
+		{
+			name:     "no value",
+			filter:   filters.NewTermsInt64Filter("Transport"),
+			expected: `{"bool": {"must_not": {"match_all": {}}}}`,
+		},
+	})
+}
+
+func TestInvalidMapping(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("No request expected with an invalid mapping, got %s %s", r.Method, r.URL.Path)
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	err := client.Start([]map[string][]byte{
+		{"node": []byte(`{"properties": {}}`)},
+		{"edge": []byte(`{"properties": {`)},
+	})
+	if err == nil {
+		t.Fatal("Expected an error with an invalid mapping")
+	}
+	if !strings.Contains(err.Error(), "edge") {
+		t.Errorf("Expected the error to name the edge mapping, got: %s", err.Error())
+	}
+}
+
+func TestMultiGet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/skydive/node/_mget" {
+			t.Errorf("Wrong multi get path: %s", r.URL.Path)
+		}
+
+		var request struct {
+			IDs []string `json:"ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(request.IDs, []string{"aaa", "bbb", "ccc"}) {
+			t.Errorf("Wrong requested ids: %v", request.IDs)
+		}
+
+		w.Write([]byte(`{"docs": [
+			{"_id": "aaa", "found": true, "_source": {"Name": "a"}},
+			{"_id": "bbb", "found": false},
+			{"_id": "ccc", "found": true, "_source": {"Name": "c"}}
+		]}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	docs, err := client.MultiGet("node", []string{"aaa", "bbb", "ccc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(docs) != 3 {
+		t.Fatalf("Expected 3 documents, got %d", len(docs))
+	}
+	for i, expected := range []struct {
+		id    string
+		found bool
+	}{{"aaa", true}, {"bbb", false}, {"ccc", true}} {
+		if docs[i].Id != expected.id || docs[i].Found != expected.found {
+			t.Errorf("Expected document %s found=%t, got %s found=%t", expected.id, expected.found, docs[i].Id, docs[i].Found)
+		}
+	}
+}
+
+func TestIndexSettings(t *testing.T) {
+	var settings string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_open"):
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == "PUT" && r.URL.Path == "/skydive_v3":
+			body, _ := ioutil.ReadAll(r.Body)
+			settings = string(body)
+		case strings.HasPrefix(r.URL.Path, "/_cluster/health"):
+			w.Write([]byte(`{"status": "green"}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		shards   int
+		replicas int
+		codec    string
+		expected string
+	}{
+		{0, -1, "", ""},
+		{3, 0, "", `{"settings": {"number_of_shards": 3, "number_of_replicas": 0}}`},
+		{0, 2, "", `{"settings": {"number_of_replicas": 2}}`},
+		{0, -1, "default", ""},
+		{0, 1, "best_compression", `{"settings": {"number_of_replicas": 1, "codec": "best_compression"}}`},
+	} {
+		settings = ""
+
+		client := newTestClient(t, hostOf(server))
+		client.SetIndexSettings(test.shards, test.replicas)
+		if err := client.SetCodec(test.codec); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.start(nil); err != nil {
+			t.Fatal(err)
+		}
+		client.Stop()
+
+		if test.expected == "" {
+			if settings != "" {
+				t.Errorf("Expected no index settings, got %s", settings)
+			}
+			continue
+		}
+
+		var result, expected interface{}
+		json.Unmarshal([]byte(settings), &result)
+		json.Unmarshal([]byte(test.expected), &expected)
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("Expected index settings %s, got %s", test.expected, settings)
+		}
+	}
+}
+
+func TestAnalysisSettings(t *testing.T) {
+	var created bool
+	var settings string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_open") && !created:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == "PUT" && r.URL.Path == "/skydive_v3":
+			body, _ := ioutil.ReadAll(r.Body)
+			settings, created = string(body), true
+		case strings.HasPrefix(r.URL.Path, "/_cluster/health"):
+			w.Write([]byte(`{"status": "green"}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	analysis := `{"analyzer": {"autocomplete": {"tokenizer": "autocomplete", "filter": ["lowercase"]}}, "tokenizer": {"autocomplete": {"type": "edge_ngram", "min_gram": 2, "max_gram": 10}}}`
+
+	client := newTestClient(t, hostOf(server))
+	if err := client.SetAnalysis(analysis); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.start(nil); err != nil {
+		t.Fatal(err)
+	}
+	client.Stop()
+
+	var result, expected interface{}
+	json.Unmarshal([]byte(settings), &result)
+	json.Unmarshal([]byte(`{"settings": {"analysis": `+analysis+`}}`), &expected)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected the analysis settings in the create body, got %s", settings)
+	}
+
+	// the existing index is only opened
+	settings = ""
+	client = newTestClient(t, hostOf(server))
+	client.SetAnalysis(analysis)
+	if err := client.start(nil); err != nil {
+		t.Fatal(err)
+	}
+	client.Stop()
+	if settings != "" {
+		t.Errorf("The settings of an existing index should not be sent, got %s", settings)
+	}
+
+	if err := client.SetAnalysis(`{"analyzer": `); err == nil {
+		t.Error("Expected invalid analysis settings to be rejected")
+	}
+}
+
+func TestInvalidCodec(t *testing.T) {
+	client := newTestClient(t, "127.0.0.1:9200")
+	if err := client.SetCodec("lz4"); err == nil {
+		t.Error("Expected an invalid codec to be rejected")
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+		}
+
+		if request["size"] != float64(0) {
+			t.Errorf("Expected no hit to be fetched, got size %v", request["size"])
+		}
+		if _, ok := request["aggs"].(map[string]interface{})["top_sources"]; !ok {
+			t.Errorf("Aggregation missing from the request: %v", request)
+		}
+		if _, ok := request["query"]; !ok {
+			t.Errorf("Query missing from the request: %v", request)
+		}
+
+		w.Write([]byte(`{"hits": {"total": 3, "hits": []}, "aggregations": {"top_sources": {"buckets": [
+			{"key": "192.168.0.1", "doc_count": 2},
+			{"key": "192.168.0.2", "doc_count": 1}
+		]}}}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	aggs, err := client.Aggregate("flow", `{"query": {"match_all": {}}}`, map[string]interface{}{
+		"top_sources": map[string]interface{}{
+			"terms": map[string]interface{}{"field": "Network.A", "size": 10},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topSources, ok := aggs["top_sources"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Aggregation missing from the result: %v", aggs)
+	}
+	if buckets, ok := topSources["buckets"].([]interface{}); !ok || len(buckets) != 2 {
+		t.Errorf("Expected 2 buckets, got: %v", topSources["buckets"])
+	}
+}
+
+func TestStop(t *testing.T) {
+	server := newBulkServer()
+	defer server.Close()
+
+	client := newStartedTestClient(t, server)
+	client.Stop()
+	client.Stop()
+
+	if client.Started() {
+		t.Error("Client should not be started once stopped")
+	}
+
+	retrySleep = func(d time.Duration) {
+		time.Sleep(time.Millisecond)
+	}
+	defer func() { retrySleep = time.Sleep }()
+
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	dead.Close()
+
+	client = newTestClient(t, hostOf(dead))
+
+	done := make(chan error)
+	go func() {
+		done <- client.Start(nil)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	go client.Stop()
+	client.Stop()
+
+	select {
+	case err := <-done:
+		if err != ErrStopped {
+			t.Errorf("Expected ErrStopped, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Start not aborted by Stop")
+	}
+}
+
+func TestDeleteByQuery(t *testing.T) {
+	var params string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/skydive/flow/_delete_by_query" {
+			t.Errorf("Wrong delete by query request: %s %s", r.Method, r.URL.Path)
+		}
+		params = r.URL.RawQuery
+		w.Write([]byte(`{"took": 10, "deleted": 17, "version_conflicts": 0}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	deleted, err := client.DeleteByQuery("flow", `{"query": {"range": {"Last": {"lt": 1000}}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 17 {
+		t.Errorf("Expected 17 deleted documents, got %d", deleted)
+	}
+	if params != "" {
+		t.Errorf("Expected no parameter, got %s", params)
+	}
+
+	if _, err := client.DeleteByQueryWithContext(context.Background(), "flow", "", true); err != nil {
+		t.Fatal(err)
+	}
+	if params != "conflicts=proceed" {
+		t.Errorf("Expected conflicts=proceed, got %s", params)
+	}
+}
+
+func TestSearchPaged(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+		}
+
+		if request["from"] != float64(20) || request["size"] != float64(10) {
+			t.Errorf("Wrong page: %v", request)
+		}
+		if _, ok := request["query"]; !ok {
+			t.Errorf("Query missing from the request: %v", request)
+		}
+		w.Write([]byte(`{"hits": {"total": 100, "hits": [{"_id": "aaa"}]}}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	result, err := client.SearchPaged("node", `{"query": {"match_all": {}}}`, 20, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Hits.Hits) != 1 {
+		t.Errorf("Expected 1 hit, got %d", len(result.Hits.Hits))
+	}
+
+	if _, err := client.SearchPaged("node", "", 9995, 10); !errors.Is(err, ErrResultWindowExceeded) {
+		t.Errorf("Expected ErrResultWindowExceeded, got: %v", err)
+	}
+}
+
+func TestIndexAuto(t *testing.T) {
+	server := newRecordingServer(t)
+	server.respond(`{"_index": "skydive_v3", "_type": "node", "_id": "AV3Ck7jqBSU8pRCZzKBv", "created": true}`)
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+
+	id, err := client.IndexAuto("node", map[string]interface{}{"Name": "eth0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "AV3Ck7jqBSU8pRCZzKBv" {
+		t.Errorf("Expected the generated id to be returned, got %s", id)
+	}
+
+	request := server.last(t)
+	if request.method != "POST" || request.path != "/skydive/node" {
+		t.Errorf("Expected the document to be posted without an id, got %s %s", request.method, request.path)
+	}
+	if request.body["Name"] != "eth0" {
+		t.Errorf("Wrong document: %v", request.body)
+	}
+
+	// single type indices
+	client.SetVersion(7)
+	if _, err := client.IndexAuto("node", map[string]interface{}{"Name": "eth0"}); err != nil {
+		t.Fatal(err)
+	}
+	if request := server.last(t); request.method != "POST" || request.path != "/skydive/_doc" {
+		t.Errorf("Expected the document to be posted without an id, got %s %s", request.method, request.path)
+	}
+}
+
+func TestRefreshPolicy(t *testing.T) {
+	server := newRecordingServer(t)
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+
+	for _, policy := range []RefreshPolicy{"", RefreshFalse, RefreshTrue, RefreshWaitFor} {
+		expected := ""
+		if policy != "" {
+			expected = "refresh=" + string(policy)
+		}
+		opts := WriteOptions{Refresh: policy}
+
+		if err := client.IndexWithOptions("node", "aaa", map[string]string{}, opts); err != nil {
+			t.Fatal(err)
+		}
+		if request := server.last(t); request.method != "PUT" || request.query != expected {
+			t.Errorf("Wrong index request for policy %q: %s ?%s", policy, request.method, request.query)
+		}
+
+		if err := client.UpdateWithOptions("node", "aaa", map[string]interface{}{"doc": map[string]string{}}, opts); err != nil {
+			t.Fatal(err)
+		}
+		if request := server.last(t); request.path != "/skydive/node/aaa/_update" || request.query != expected {
+			t.Errorf("Wrong update request for policy %q: %s?%s", policy, request.path, request.query)
+		}
+
+		if _, err := client.DeleteWithOptions("node", "aaa", opts); err != nil {
+			t.Fatal(err)
+		}
+		if request := server.last(t); request.method != "DELETE" || request.query != expected {
+			t.Errorf("Wrong delete request for policy %q: %s ?%s", policy, request.method, request.query)
+		}
+	}
+
+	if err := client.IndexWithOptions("node", "aaa", map[string]string{}, WriteOptions{Refresh: "always"}); err == nil {
+		t.Error("Expected an error for an invalid refresh policy")
+	}
+}
+
+func TestIndexPipeline(t *testing.T) {
+	server := newRecordingServer(t)
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+
+	if err := client.Index("flow", "aaa", map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+	if request := server.last(t); request.query != "" {
+		t.Errorf("No pipeline expected by default, got ?%s", request.query)
+	}
+
+	if err := client.IndexWithOptions("flow", "aaa", map[string]string{}, WriteOptions{Pipeline: "geoip"}); err != nil {
+		t.Fatal(err)
+	}
+	if request := server.last(t); request.method != "PUT" || request.query != "pipeline=geoip" {
+		t.Errorf("Expected the pipeline query parameter, got %s ?%s", request.method, request.query)
+	}
+}
+
+func TestVersionConflict(t *testing.T) {
+	var lock sync.Mutex
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		queries = append(queries, r.URL.RawQuery)
+		lock.Unlock()
+
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"error": {"type": "version_conflict_engine_exception", "reason": "[node][aaa]: version conflict"}, "status": 409}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+
+	opts := WriteOptions{IfSeqNo: 10, IfPrimaryTerm: 1}
+	err := client.UpdateWithOptions("node", "aaa", map[string]interface{}{"doc": map[string]string{}}, opts)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected ErrVersionConflict, got: %v", err)
+	}
+
+	if err := client.IndexWithOptions("node", "aaa", map[string]string{}, WriteOptions{Version: 3}); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected ErrVersionConflict, got: %v", err)
+	}
+
+	if _, err := client.DeleteWithOptions("node", "aaa", WriteOptions{Version: 3}); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected ErrVersionConflict, got: %v", err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	// conflicts are not retried
+	expected := []string{"if_primary_term=1&if_seq_no=10", "version=3", "version=3"}
+	if !reflect.DeepEqual(queries, expected) {
+		t.Errorf("Expected the requests %v, got %v", expected, queries)
+	}
+}
+
+func TestSearchRaw(t *testing.T) {
+	server := newRecordingServer(t)
+	defer server.Close()
+
+	response := `{"took": 3, "hits": {"total": {"value": 1, "relation": "eq"}, "hits": [{"_id": "aaa", "_source": {"Name": "eth0"}}]}}`
+	server.respond(response)
+
+	client := newTestClient(t, hostOf(server.Server))
+	data, err := client.SearchRaw("node", `{"query": {"match_all": {}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != response {
+		t.Errorf("Expected the raw response, got %s", string(data))
+	}
+	if request := server.last(t); request.path != "/skydive/node/_search" {
+		t.Errorf("Wrong search request: %s", request.path)
+	}
+
+	errServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": {"type": "parsing_exception"}}`))
+	}))
+	defer errServer.Close()
+
+	client = newTestClient(t, hostOf(errServer))
+	data, err = client.SearchRaw("node", `{"query": {"match_all": {}}}`)
+	var esErr elastigo.ESError
+	if !errors.As(err, &esErr) || esErr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a bad request error, got: %v", err)
+	}
+	if data != nil {
+		t.Errorf("No data expected on error, got %s", string(data))
+	}
+}
+
+func TestUnmarshalHits(t *testing.T) {
+	raw := func(s string) *json.RawMessage {
+		m := json.RawMessage(s)
+		return &m
+	}
+
+	var result elastigo.SearchResult
+	result.Hits.Hits = []elastigo.Hit{
+		{Id: "aaa", Source: raw(`{"Name": "eth0", "MTU": 1500}`)},
+		{Id: "bbb", Source: raw(`{"Name": "lo", "MTU": 65536}`)},
+	}
+
+	type node struct {
+		Name string
+		MTU  int64
+	}
+
+	var nodes []node
+	if err := UnmarshalHits(result, &nodes); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []node{{"eth0", 1500}, {"lo", 65536}}; !reflect.DeepEqual(nodes, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, nodes)
+	}
+
+	var pointers []*node
+	if err := UnmarshalHits(elastigo.SearchResult{}, &pointers); err != nil || len(pointers) != 0 {
+		t.Errorf("Expected no hits, got %v: %v", pointers, err)
+	}
+
+	if err := UnmarshalHits(result, nodes); err == nil {
+		t.Error("Expected an error when not given a pointer")
+	}
+}
+
+func TestTrackTotalHits(t *testing.T) {
+	server := newRecordingServer(t)
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+	client.SetVersion(7)
+
+	if _, err := client.Search("node", `{"query": {"match_all": {}}}`); err != nil {
+		t.Fatal(err)
+	}
+	if request := server.last(t); request.body["track_total_hits"] != nil {
+		t.Errorf("The cluster default should be kept: %v", request.body)
+	}
+
+	if _, err := client.SearchWithOptions("node", `{"query": {"match_all": {}}}`, SearchOptions{TrackTotalHits: TrackAllHits}); err != nil {
+		t.Fatal(err)
+	}
+	if request := server.last(t); request.body["track_total_hits"] != true {
+		t.Errorf("Expected all the hits to be tracked: %v", request.body)
+	}
+
+	if _, err := client.SearchPagedWithOptions("node", `{"query": {"match_all": {}}}`, 0, 10, SearchOptions{TrackTotalHits: 50000}); err != nil {
+		t.Fatal(err)
+	}
+	request := server.last(t)
+	if request.body["track_total_hits"] != float64(50000) || request.body["size"] != float64(10) {
+		t.Errorf("Expected the hits to be tracked up to 50000: %v", request.body)
+	}
+
+	// older versions always count all the hits
+	client.SetVersion(6)
+	if _, err := client.SearchWithOptions("node", `{}`, SearchOptions{TrackTotalHits: TrackAllHits}); err != nil {
+		t.Fatal(err)
+	}
+	if request := server.last(t); request.body["track_total_hits"] != nil {
+		t.Errorf("track_total_hits is not supported before Elasticsearch 7: %v", request.body)
+	}
+}
+
+func TestSearchWithTotal(t *testing.T) {
+	server := newRecordingServer(t)
+	server.respond(`{"hits": {"total": {"value": 12345, "relation": "eq"}, "hits": [{"_id": "aaa"}, {"_id": "bbb"}]}}`)
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+	client.SetVersion(7)
+
+	result, total, err := client.SearchWithTotal("node", `{"query": {"match_all": {}}}`, 20, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 12345 {
+		t.Errorf("Expected a total of 12345, got %d", total)
+	}
+	if len(result.Hits.Hits) != 2 || result.Hits.Hits[0].Id != "aaa" || result.Hits.Hits[1].Id != "bbb" {
+		t.Errorf("Wrong page of hits: %v", result.Hits.Hits)
+	}
+
+	request := server.last(t)
+	if request.body["track_total_hits"] != true {
+		t.Errorf("Expected all the hits to be tracked: %v", request.body)
+	}
+	if request.body["from"] != float64(20) || request.body["size"] != float64(2) {
+		t.Errorf("Wrong page: %v", request.body)
+	}
+
+	// the total is a number before Elasticsearch 7
+	server.respond(`{"hits": {"total": 42, "hits": [{"_id": "aaa"}]}}`)
+	client.SetVersion(6)
+	if _, total, err = client.SearchWithTotal("node", `{}`, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if total != 42 {
+		t.Errorf("Expected a total of 42, got %d", total)
+	}
+
+	if _, _, err := client.SearchWithTotal("node", `{}`, 9995, 10); !errors.Is(err, ErrResultWindowExceeded) {
+		t.Errorf("Expected ErrResultWindowExceeded, got: %v", err)
+	}
+}
+
+func TestMinScore(t *testing.T) {
+	server := newRecordingServer(t)
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+
+	if _, err := client.SearchWithOptions("node", `{"query": {"match": {"Name": "eth0"}}}`, SearchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := server.last(t).body["min_score"]; found {
+		t.Error("No min_score expected when unset")
+	}
+
+	for _, score := range []float64{0, 1.5} {
+		score := score
+		if _, err := client.SearchWithOptions("node", `{"query": {"match": {"Name": "eth0"}}}`, SearchOptions{MinScore: &score}); err != nil {
+			t.Fatal(err)
+		}
+		if request := server.last(t); request.body["min_score"] != score {
+			t.Errorf("Expected a min_score of %v: %v", score, request.body)
+		}
+	}
+}
+
+func TestKeywordFields(t *testing.T) {
+	client := newTestClient(t, "127.0.0.1:9200")
+	client.SetKeywordFields("Metadata/Description")
+
+	testFormatFilter(t, client, []filterTest{
+		{
+			name:     "keyword field",
+			filter:   filters.NewTermStringFilter("Description", "uplink"),
+			prefix:   "Metadata/",
+			expected: `{"term": {"Metadata/Description.keyword": "uplink"}}`,
+		},
+		{
+			name:     "other field",
+			filter:   filters.NewTermStringFilter("Name", "eth0"),
+			prefix:   "Metadata/",
+			expected: `{"term": {"Metadata/Name": "eth0"}}`,
+		},
+	})
+}
+
+func TestFilterCombinators(t *testing.T) {
+	name := filters.NewTermStringFilter("Name", "eth0")
+	mtu := filters.NewGtInt64Filter("MTU", 1500)
+	state := filters.NewTermStringFilter("State", "UP")
+
+	testFormatFilter(t, newTestClient(t, "127.0.0.1:9200"), []filterTest{
+		{
+			name:     "not",
+			filter:   filters.Not(name),
+			expected: `{"bool": {"must_not": [{"term": {"Name": "eth0"}}]}}`,
+		},
+		{
+			name:     "double negation",
+			filter:   filters.Not(filters.Not(name)),
+			expected: `{"term": {"Name": "eth0"}}`,
+		},
+		{
+			name:     "and",
+			filter:   filters.And(name, nil, mtu),
+			expected: `{"bool": {"must": [{"term": {"Name": "eth0"}}, {"range": {"MTU": {"gt": 1500}}}]}}`,
+		},
+		{
+			name:     "nested and",
+			filter:   filters.And(filters.And(name, mtu), state),
+			expected: `{"bool": {"must": [{"term": {"Name": "eth0"}}, {"range": {"MTU": {"gt": 1500}}}, {"term": {"State": "UP"}}]}}`,
+		},
+		{
+			name:     "or",
+			filter:   filters.Or(name, filters.Not(state)),
+			expected: `{"bool": {"should": [{"term": {"Name": "eth0"}}, {"bool": {"must_not": [{"term": {"State": "UP"}}]}}]}}`,
+		},
+		{
+			name:     "single filter",
+			filter:   filters.Or(nil, mtu),
+			expected: `{"range": {"MTU": {"gt": 1500}}}`,
+		},
+	})
+}
+
+func TestIPRangeFilter(t *testing.T) {
+	ipFilter, err := filters.NewIPRangeFilter("Network.A", "192.168.1.0/24", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stringFilter, err := filters.NewIPRangeFilter("Network.A", "192.168.1.0/24", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testFormatFilter(t, newTestClient(t, "127.0.0.1:9200"), []filterTest{
+		{
+			name:     "ip field",
+			filter:   ipFilter,
+			expected: `{"term": {"Network.A": "192.168.1.0/24"}}`,
+		},
+		{
+			name:     "string field",
+			filter:   stringFilter,
+			expected: `{"regexp": {"Network.A": "192\\.168\\.1\\.(25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])"}}`,
+		},
+		{
+			name:     "ipv6 string field",
+			filter:   &filters.Filter{IPRangeFilter: &filters.IPRangeFilter{Key: "Network.A", CIDR: "fd00::/64"}},
+			expected: `{"bool": {"must_not": {"match_all": {}}}}`,
+		},
+		{
+			name:     "invalid network",
+			filter:   &filters.Filter{IPRangeFilter: &filters.IPRangeFilter{Key: "Network.A", CIDR: "192.168.1.0/33"}},
+			expected: `{"bool": {"must_not": {"match_all": {}}}}`,
+		},
+	})
+
+	if _, err := filters.NewIPRangeFilter("Network.A", "192.168.1/24", true); err == nil {
+		t.Error("Expected an error for an invalid network")
+	}
+
+	// the addresses are compared as strings on keyword fields
+	for cidr, addresses := range map[string]map[string]bool{
+		"10.0.0.0/24":  {"10.0.0.3": true, "10.0.0.255": true, "10.0.1.3": false, "10.0.0.2555": false},
+		"10.0.0.0/25":  {"10.0.0.127": true, "10.0.0.128": false, "10.0.0.12": true},
+		"10.16.0.0/12": {"10.31.200.1": true, "10.32.0.1": false, "10.1.0.1": false},
+		"10.0.0.1/32":  {"10.0.0.1": true, "10.0.0.10": false},
+	} {
+		f := &filters.IPRangeFilter{Key: "Network.A", CIDR: cidr}
+		re, err := f.Regexp()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// elasticsearch regular expressions are always anchored
+		compiled := regexp.MustCompile("^" + re + "$")
+		for address, expected := range addresses {
+			if compiled.MatchString(address) != expected {
+				t.Errorf("%s expected to match %s: %v, regexp %s", cidr, address, expected, re)
+			}
+		}
+	}
+}
+
+func TestMatchFilter(t *testing.T) {
+	single, err := filters.NewMatchFilter("Description", "bridge", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	and, err := filters.NewMatchFilter("Description", "linux bridge", "AND")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testFormatFilter(t, newTestClient(t, "127.0.0.1:9200"), []filterTest{
+		{
+			name:     "single word",
+			filter:   single,
+			expected: `{"match": {"Description": "bridge"}}`,
+		},
+		{
+			name:     "multiple words with operator",
+			filter:   and,
+			expected: `{"match": {"Description": {"query": "linux bridge", "operator": "and"}}}`,
+		},
+	})
+
+	if _, err := filters.NewMatchFilter("Description", "linux bridge", "xor"); err == nil {
+		t.Error("Expected an error for an invalid operator")
+	}
+}
+
+func TestDateRangeFilter(t *testing.T) {
+	relative, err := filters.NewDateRangeFilter("Metric.Last", "now-15m", "now")
+	if err != nil {
+		t.Fatal(err)
+	}
+	absolute, err := filters.NewDateRangeFilter("Metric.Last", "2017-03-05T00:00:00Z", "2017-03-05T23:59:59Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testFormatFilter(t, newTestClient(t, "127.0.0.1:9200"), []filterTest{
+		{
+			name:     "date math",
+			filter:   relative,
+			expected: `{"range": {"Metric.Last": {"gte": "now-15m", "lte": "now"}}}`,
+		},
+		{
+			name:     "iso 8601",
+			filter:   absolute,
+			expected: `{"range": {"Metric.Last": {"gte": "2017-03-05T00:00:00Z", "lte": "2017-03-05T23:59:59Z"}}}`,
+		},
+	})
+
+	if _, err := filters.NewDateRangeFilter("Metric.Last", "", "now"); err == nil {
+		t.Error("Expected an error for an empty bound")
+	}
+	if _, err := filters.NewDateRangeFilter("Metric.Last", "now-15x", "now"); err == nil {
+		t.Error("Expected an error for an invalid date unit")
+	}
+
+	now := time.Date(2017, time.March, 5, 12, 30, 0, 0, time.UTC)
+	for expr, expected := range map[string]time.Time{
+		"now-15m":              time.Date(2017, time.March, 5, 12, 15, 0, 0, time.UTC),
+		"now+1d/d":             time.Date(2017, time.March, 6, 0, 0, 0, 0, time.UTC),
+		"2017-03-01||+1M":      time.Date(2017, time.April, 1, 0, 0, 0, 0, time.UTC),
+		"2017-03-05T10:00:00Z": time.Date(2017, time.March, 5, 10, 0, 0, 0, time.UTC),
+	} {
+		parsed, err := filters.ParseDateMath(expr, now, false)
+		if err != nil {
+			t.Errorf("Unable to parse %s: %s", expr, err.Error())
+		} else if !parsed.Equal(expected) {
+			t.Errorf("Expected %s for %s, got %s", expected, expr, parsed)
+		}
+	}
+
+	// rounded upper bounds include the whole unit
+	if parsed, _ := filters.ParseDateMath("now/d", now, true); !parsed.Equal(time.Date(2017, time.March, 5, 23, 59, 59, 999000000, time.UTC)) {
+		t.Errorf("Wrong rounded upper bound: %s", parsed)
+	}
+
+	// dates are rounded in UTC whatever the time zone of now
+	paris := time.FixedZone("CET", 3600)
+	if parsed, _ := filters.ParseDateMath("now/d", time.Date(2017, time.March, 6, 0, 30, 0, 0, paris), false); !parsed.Equal(time.Date(2017, time.March, 5, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the date to be rounded in UTC, got %s", parsed)
+	}
+
+	// the skydive timestamps are in seconds
+	last := time.Now().Add(-5 * time.Minute).Unix()
+	if !relative.Eval(mapGetter{"Metric.Last": last}) {
+		t.Errorf("Expected a timestamp of 5 minutes ago to match %s..%s", relative.DateRangeFilter.From, relative.DateRangeFilter.To)
+	}
+	if relative.Eval(mapGetter{"Metric.Last": time.Now().Add(-time.Hour).Unix()}) {
+		t.Error("A timestamp of an hour ago should not match")
+	}
+
+	day, _ := filters.NewDateRangeFilter("Metric.Last", "1488672000||/d", "1488672000||/d")
+	if !day.Eval(mapGetter{"Metric.Last": time.Date(2017, time.March, 5, 18, 0, 0, 0, time.UTC).Unix()}) {
+		t.Error("Expected an epoch second anchor to match the same day")
+	}
+}
+
+func TestGeoDistanceFilter(t *testing.T) {
+	filter, err := filters.NewGeoDistanceFilter("Metadata.Location", 48.85, 2.35, "50km")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testFormatFilter(t, newTestClient(t, "127.0.0.1:9200"), []filterTest{
+		{
+			name:     "valid",
+			filter:   filter,
+			expected: `{"geo_distance": {"distance": "50km", "Metadata.Location": {"lat": 48.85, "lon": 2.35}}}`,
+		},
+		{
+			name:     "out of range latitude",
+			filter:   &filters.Filter{GeoDistanceFilter: &filters.GeoDistanceFilter{Key: "Metadata.Location", Lat: 91, Lon: 2.35, Distance: "50km"}},
+			expected: `{"bool": {"must_not": {"match_all": {}}}}`,
+		},
+	})
+
+	for _, invalid := range []struct {
+		lat, lon float64
+		distance string
+	}{{91, 2.35, "50km"}, {-90.5, 2.35, "50km"}, {48.85, 181, "50km"}, {48.85, 2.35, "50parsec"}, {48.85, 2.35, "km"}} {
+		if _, err := filters.NewGeoDistanceFilter("Metadata.Location", invalid.lat, invalid.lon, invalid.distance); err == nil {
+			t.Errorf("Expected an error for %v", invalid)
+		}
+	}
+}
+
+func TestRequestRetry(t *testing.T) {
+	requestRetryDelay = time.Millisecond
+	defer func() { requestRetryDelay = 100 * time.Millisecond }()
+
+	var requests, failures int
+	code := http.StatusServiceUnavailable
+	response := `{"_id": "aaa", "found": true, "_source": {}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests++; requests <= failures {
+			w.WriteHeader(code)
+			return
+		}
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+
+	failures = 2
+	if _, err := client.Get("node", "aaa"); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 {
+		t.Errorf("Expected 3 requests, got %d", requests)
+	}
+
+	requests, failures = 0, 3
+	if _, err := client.Get("node", "aaa"); err == nil {
+		t.Error("Expected an error once the maximum attempts are reached")
+	}
+	if requests != 3 {
+		t.Errorf("Expected 3 requests, got %d", requests)
+	}
+
+	requests, failures, code = 0, 1, http.StatusBadRequest
+	if err := client.Index("node", "aaa", map[string]string{}); err == nil {
+		t.Error("Expected an error for a bad request")
+	}
+	if requests != 1 {
+		t.Errorf("Expected a bad request not to be retried, got %d requests", requests)
+	}
+
+	// a document with a generated id would be indexed twice
+	requests, failures, code = 0, 1, http.StatusServiceUnavailable
+	if err := client.IndexChild("metric", "flow-1", "", map[string]string{}); err == nil {
+		t.Error("Expected an error for a server error")
+	}
+	if requests != 1 {
+		t.Errorf("Expected a POST not to be retried, got %d requests", requests)
+	}
+
+	requests, failures, response = 0, 0, `{"_id": `
+	if _, err := client.Get("node", "aaa"); err == nil {
+		t.Error("Expected an error for an invalid response")
+	}
+	if requests != 1 {
+		t.Errorf("Expected an invalid response not to be retried, got %d requests", requests)
+	}
+
+	// connection errors are retried
+	server.Close()
+	if _, err := client.Get("node", "aaa"); err == nil || !retryable(err) {
+		t.Errorf("Expected a retryable connection error, got: %v", err)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	var paths []string
+	code := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		w.WriteHeader(code)
+		w.Write([]byte(`{"_shards": {"total": 1, "successful": 1, "failed": 0}}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	if err := client.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || paths[0] != "POST /skydive_v3/_refresh" {
+		t.Errorf("Expected the index to be refreshed, got: %v", paths)
+	}
+
+	code = http.StatusForbidden
+	if err := client.Refresh(); err == nil {
+		t.Error("Expected the refresh error to be returned")
+	}
+}
+
+func TestMappingConflict(t *testing.T) {
+	var mappingRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/_cluster/health"):
+			w.Write([]byte(`{"status": "green"}`))
+		case strings.Contains(r.URL.Path, "/_mapping/"):
+			mappingRequests++
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"type": "illegal_argument_exception",
+				"reason": "mapper [Metadata.Name] of different type, current_type [long], merged_type [string]"}, "status": 400}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	err := client.Start([]map[string][]byte{{"node": []byte(`{}`)}})
+	if !errors.Is(err, ErrMappingConflict) {
+		t.Fatalf("Expected ErrMappingConflict, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "node") {
+		t.Errorf("Expected the error to name the node mapping, got: %s", err.Error())
+	}
+	if mappingRequests != 1 {
+		t.Errorf("Expected the mapping not to be retried, got %d requests", mappingRequests)
+	}
+}
+
+func TestSearchFields(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+		}
+
+		var expected interface{}
+		json.Unmarshal([]byte(`{"includes": ["ID", "Metadata.Name"], "excludes": ["Metadata.Captures*"]}`), &expected)
+		if !reflect.DeepEqual(request["_source"], expected) {
+			t.Errorf("Wrong source filtering: %v", request["_source"])
+		}
+
+		w.Write([]byte(`{"hits": {"total": 1, "hits": [{"_id": "aaa", "_source": {"ID": "aaa", "Metadata": {"Name": "eth0"}}}]}}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	result, err := client.SearchFields("node", `{"query": {"match_all": {}}}`, []string{"ID", "Metadata.Name"}, []string{"Metadata.Captures*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Hits.Hits) != 1 {
+		t.Fatalf("Expected 1 hit, got %d", len(result.Hits.Hits))
+	}
+
+	var node struct {
+		ID       string
+		Metadata struct {
+			Name string
+		}
+	}
+	if err := json.Unmarshal(*result.Hits.Hits[0].Source, &node); err != nil {
+		t.Fatal(err)
+	}
+	if node.ID != "aaa" || node.Metadata.Name != "eth0" {
+		t.Errorf("Wrong document: %+v", node)
+	}
+}
+
+func TestScriptFilter(t *testing.T) {
+	script := filters.NewScriptFilter("doc['RxBytes'].value > doc['TxBytes'].value * params.ratio", map[string]string{"ratio": "2"})
+
+	client := newTestClient(t, "127.0.0.1:9200")
+	testFormatFilter(t, client, []filterTest{
+		{
+			name:     "disabled",
+			filter:   script,
+			expected: `{"bool": {"must_not": {"match_all": {}}}}`,
+		},
+	})
+
+	client.SetAllowScripts(true)
+	testFormatFilter(t, client, []filterTest{
+		{
+			name:   "enabled",
+			filter: script,
+			expected: `{"script": {"script": {
+				"inline": "doc['RxBytes'].value > doc['TxBytes'].value * params.ratio",
+				"lang": "painless",
+				"params": {"ratio": "2"}
+			}}}`,
+		},
+		{
+			name:     "empty",
+			filter:   filters.NewScriptFilter(" ", nil),
+			expected: `{"bool": {"must_not": {"match_all": {}}}}`,
+		},
+	})
+
+	client.SetVersion(7)
+	testFormatFilter(t, client, []filterTest{
+		{
+			name:     "source",
+			filter:   filters.NewScriptFilter("doc['RxBytes'].value > 0", nil),
+			expected: `{"script": {"script": {"source": "doc['RxBytes'].value > 0", "lang": "painless"}}}`,
+		},
+	})
+}
+
+func TestArrayLenFilter(t *testing.T) {
+	gt, err := filters.NewArrayLenFilter("IPV4", "gt", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eq, err := filters.NewArrayLenFilter("IPV4", "EQ", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := filters.NewArrayLenFilter("IPV4", "ne", 1); err == nil {
+		t.Error("Expected an error for an unknown operator")
+	}
+
+	client := newTestClient(t, "127.0.0.1:9200")
+	testFormatFilter(t, client, []filterTest{
+		{
+			name:     "disabled",
+			filter:   gt,
+			expected: `{"bool": {"must_not": {"match_all": {}}}}`,
+		},
+	})
+
+	client.SetAllowScripts(true)
+	testFormatFilter(t, client, []filterTest{
+		{
+			name:   "gt",
+			filter: gt,
+			prefix: "Metadata/",
+			expected: `{"script": {"script": {
+				"inline": "doc[params.field].size() > params.value",
+				"lang": "painless",
+				"params": {"field": "Metadata/IPV4", "value": 2}
+			}}}`,
+		},
+		{
+			name:   "eq",
+			filter: eq,
+			expected: `{"script": {"script": {
+				"inline": "doc[params.field].size() == params.value",
+				"lang": "painless",
+				"params": {"field": "IPV4", "value": 1}
+			}}}`,
+		},
+		{
+			name:     "invalid operator",
+			filter:   &filters.Filter{ArrayLenFilter: &filters.ArrayLenFilter{Key: "IPV4", Operator: "ne"}},
+			expected: `{"bool": {"must_not": {"match_all": {}}}}`,
+		},
+	})
+}
+
+func TestStartBackoff(t *testing.T) {
+	var delays []time.Duration
+	retrySleep = func(d time.Duration) {
+		delays = append(delays, d)
+	}
+	defer func() { retrySleep = time.Sleep }()
+
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	dead.Close()
+
+	client := newTestClient(t, hostOf(dead))
+	client.SetStartRetry(4*time.Second, 15*time.Second)
+
+	if err := client.Start(nil); err == nil {
+		t.Fatal("Start should fail once the connect timeout expired")
+	}
+
+	if len(delays) < 4 {
+		t.Fatalf("Expected several attempts, got %v", delays)
+	}
+	for i, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		if delays[i] != expected {
+			t.Errorf("Expected delay %s for attempt %d, got %s", expected, i, delays[i])
+		}
+	}
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package elasticsearch
+
+import "fmt"
+
+// dynamicModes ranks the ways the fields missing from a mapping are handled,
+// from the most to the least permissive: true adds them to the mapping,
+// false stores them without indexing them and strict rejects the documents
+var dynamicModes = map[string]int{"true": 0, "false": 1, "strict": 2}
+
+// parseDynamic returns the dynamic setting of a mapping, given either as a
+// boolean or as a string, empty when not set
+func parseDynamic(dynamic interface{}) (string, error) {
+	var mode string
+	switch d := dynamic.(type) {
+	case nil:
+		return "", nil
+	case bool:
+		mode = fmt.Sprintf("%t", d)
+	case string:
+		mode = d
+	}
+
+	if _, ok := dynamicModes[mode]; !ok {
+		return "", fmt.Errorf("Invalid dynamic setting %v, has to be true, false or strict", dynamic)
+	}
+	return mode, nil
+}
+
+// SetDynamic sets how the fields missing from the mapping of a document type
+// are handled, overriding the dynamic setting of the mapping given to Start.
+// With false the fields of unexpected documents are stored but not indexed,
+// which keeps the mapping from growing with each new field. An empty mode
+// keeps the setting of the mapping.
+func (c *ElasticSearchClient) SetDynamic(obj string, mode string) error {
+	if mode == "" {
+		delete(c.dynamic, obj)
+		return nil
+	}
+
+	if _, err := parseDynamic(mode); err != nil {
+		return fmt.Errorf("Invalid %s mapping: %s", obj, err.Error())
+	}
+
+	if c.dynamic == nil {
+		c.dynamic = make(map[string]string)
+	}
+	c.dynamic[obj] = mode
+	return nil
+}
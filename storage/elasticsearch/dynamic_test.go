@@ -0,0 +1,125 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package elasticsearch
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDynamicMapping(t *testing.T) {
+	server := newRecordingServer(t)
+	server.respond(`{"status": "green"}`)
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+	if err := client.SetDynamic("edge", "strict"); err != nil {
+		t.Fatal(err)
+	}
+
+	mappings := []map[string][]byte{
+		{"node": []byte(`{"dynamic": false, "properties": {"ID": {"type": "string"}}}`)},
+		{"edge": []byte(`{"dynamic": "true", "properties": {"Parent": {"type": "string"}}}`)},
+	}
+	if err := client.start(mappings); err != nil {
+		t.Fatal(err)
+	}
+	client.Stop()
+
+	dynamic := make(map[string]interface{})
+	server.Lock()
+	for _, request := range server.requests {
+		switch request.path {
+		case "/skydive_v3/_mapping/node", "/skydive_v3/_mapping/edge":
+			dynamic[request.path] = request.body["dynamic"]
+		}
+	}
+	server.Unlock()
+
+	if dynamic["/skydive_v3/_mapping/node"] != false {
+		t.Errorf("Expected the dynamic setting of the node mapping to be kept, got %v", dynamic["/skydive_v3/_mapping/node"])
+	}
+	if dynamic["/skydive_v3/_mapping/edge"] != "strict" {
+		t.Errorf("Expected the dynamic setting of the edge mapping to be overridden, got %v", dynamic["/skydive_v3/_mapping/edge"])
+	}
+
+	// a single type gets the most restrictive setting
+	client = newTestClient(t, hostOf(server.Server))
+	if err := client.SetVersion(7); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, override := range []struct {
+		mode     string
+		expected string
+	}{
+		{"", "false"},
+		{"strict", "strict"},
+	} {
+		if err := client.SetDynamic("edge", override.mode); err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := client.templateBody(mappings)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var template struct {
+			Mappings map[string]struct {
+				Dynamic string `json:"dynamic"`
+			} `json:"mappings"`
+		}
+		if err := json.Unmarshal(body, &template); err != nil {
+			t.Fatal(err)
+		}
+		if mode := template.Mappings[singleType].Dynamic; mode != override.expected {
+			t.Errorf("Expected the %s dynamic setting in the template, got %s: %s", override.expected, mode, string(body))
+		}
+	}
+}
+
+func TestInvalidDynamic(t *testing.T) {
+	client := newTestClient(t, "127.0.0.1:9200")
+	if err := client.SetDynamic("flow", "runtime"); err == nil {
+		t.Error("Expected an error for an invalid dynamic setting")
+	}
+
+	if err := validateMappings([]map[string][]byte{{"flow": []byte(`{"dynamic": "no"}`)}}); err == nil {
+		t.Error("Expected an error for a mapping with an invalid dynamic setting")
+	}
+
+	mapping, err := NewMapping().AddField("UUID", "keyword").SetDynamic("strict").JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m map[string]interface{}
+	json.Unmarshal(mapping, &m)
+	if m["dynamic"] != "strict" {
+		t.Errorf("Expected the dynamic setting in the built mapping, got %s", string(mapping))
+	}
+
+	if _, err := NewMapping().SetDynamic("yes").JSON(); err == nil {
+		t.Error("Expected an error for an invalid dynamic setting of a built mapping")
+	}
+}
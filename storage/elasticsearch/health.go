@@ -0,0 +1,132 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/skydive-project/skydive/logging"
+)
+
+// healthPollInterval is the delay between two cluster health requests
+var healthPollInterval = time.Second
+
+var healthStatusLevels = map[string]int{
+	"red":    0,
+	"yellow": 1,
+	"green":  2,
+}
+
+type clusterHealth struct {
+	Status   string `json:"status"`
+	TimedOut bool   `json:"timed_out"`
+}
+
+// SetWaitForStatus sets the health status the index has to reach before the
+// client is started and the maximum time to wait for it
+func (c *ElasticSearchClient) SetWaitForStatus(status string, timeout time.Duration) error {
+	if _, ok := healthStatusLevels[status]; !ok {
+		return fmt.Errorf("Invalid health status %s, has to be red, yellow or green", status)
+	}
+
+	c.healthStatus = status
+	c.healthTimeout = timeout
+	return nil
+}
+
+func (c *ElasticSearchClient) indexHealth(query string) (*clusterHealth, error) {
+	var health clusterHealth
+
+	code, data, err := c.request("GET", "/_cluster/health/"+c.indexPattern(), query, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Elasticsearch answers with a 408 when the wanted status was not
+	// reached in time, the body still contains the current status
+	if code != http.StatusOK && code != http.StatusRequestTimeout {
+		return nil, newResponseError(code, data)
+	}
+
+	if err := json.Unmarshal(data, &health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// waitForHealth waits for the index to reach at least the configured status
+func (c *ElasticSearchClient) waitForHealth() error {
+	query := fmt.Sprintf("wait_for_status=%s&timeout=%dms", c.healthStatus, c.healthTimeout.Nanoseconds()/int64(time.Millisecond))
+
+	deadline := time.Now().Add(c.healthTimeout)
+	for {
+		health, err := c.indexHealth(query)
+		if err != nil {
+			return err
+		}
+
+		if healthStatusLevels[health.Status] >= healthStatusLevels[c.healthStatus] {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Index %s health is %s, expected at least %s", c.IndexName(), health.Status, c.healthStatus)
+		}
+
+		logging.GetLogger().Debugf("Waiting for index %s to be %s, currently %s", c.IndexName(), c.healthStatus, health.Status)
+
+		// the client may be stopped while a Start is pending
+		select {
+		case <-time.After(healthPollInterval):
+		case <-c.stopped:
+			return ErrStopped
+		}
+	}
+}
+
+// HealthCheck checks that the Elasticsearch cluster is reachable and that
+// its health is not red
+func (c *ElasticSearchClient) HealthCheck() error {
+	var health clusterHealth
+
+	code, data, err := c.request("GET", "/_cluster/health", "", "")
+	if err != nil {
+		return err
+	}
+
+	if code != http.StatusOK {
+		return newResponseError(code, data)
+	}
+
+	if err := json.Unmarshal(data, &health); err != nil {
+		return err
+	}
+
+	if health.Status == "red" {
+		return fmt.Errorf("Elasticsearch cluster health is %s", health.Status)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWaitForHealth(t *testing.T) {
+	healthPollInterval = 10 * time.Millisecond
+
+	statuses := []string{"red", "yellow"}
+	wanted := "yellow"
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[requests]
+		if requests < len(statuses)-1 {
+			requests++
+		}
+
+		if r.URL.Query().Get("wait_for_status") != wanted {
+			t.Errorf("Wrong wait_for_status parameter: %s", r.URL.RawQuery)
+		}
+		w.Write([]byte(`{"status": "` + status + `", "timed_out": false}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	if err := client.waitForHealth(); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected to wait for the yellow status, got %d requests", requests+1)
+	}
+
+	requests, wanted = 0, "green"
+	client.SetWaitForStatus("green", 50*time.Millisecond)
+	if err := client.waitForHealth(); err == nil {
+		t.Error("Expected an error as the index never becomes green")
+	}
+}
+
+func TestWaitForHealthStop(t *testing.T) {
+	healthPollInterval = time.Hour
+	defer func() { healthPollInterval = time.Second }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "red", "timed_out": false}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	client.SetWaitForStatus("green", time.Hour)
+
+	done := make(chan error)
+	go func() { done <- client.waitForHealth() }()
+
+	time.Sleep(50 * time.Millisecond)
+	client.Stop()
+
+	select {
+	case err := <-done:
+		if err != ErrStopped {
+			t.Errorf("Expected ErrStopped, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Stop didn't interrupt the wait for the index health")
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	code, status := http.StatusOK, "green"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_cluster/health" {
+			t.Errorf("Wrong health check path: %s", r.URL.Path)
+		}
+		w.WriteHeader(code)
+		w.Write([]byte(`{"status": "` + status + `", "timed_out": false}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	if err := client.HealthCheck(); err != nil {
+		t.Fatal(err)
+	}
+
+	status = "red"
+	if err := client.HealthCheck(); err == nil {
+		t.Error("Expected an error for a red cluster")
+	}
+
+	code, status = http.StatusServiceUnavailable, "green"
+	if err := client.HealthCheck(); err == nil {
+		t.Error("Expected an error for a non 200 response")
+	}
+}
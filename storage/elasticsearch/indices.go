@@ -0,0 +1,88 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package elasticsearch
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// IndexInfo describes an index of the client
+type IndexInfo struct {
+	Name string
+	// DocCount is the number of documents, nested ones included
+	DocCount int64
+	// StoreSize is the disk usage in bytes, replicas included
+	StoreSize int64
+}
+
+// indicesPattern returns the patterns of the versioned indices of the
+// client, leaving out the indices of the deployments sharing the prefix
+func (c *ElasticSearchClient) indicesPattern() string {
+	patterns := []string{c.AliasName() + "_v*"}
+	if c.indexPerType {
+		for _, obj := range c.types {
+			patterns = append(patterns, c.typeAlias(obj)+"_v*")
+		}
+	}
+	return strings.Join(patterns, ",")
+}
+
+// ListIndices returns the versioned indices of the client, the daily and per
+// type indices included, sorted by name
+func (c *ElasticSearchClient) ListIndices() ([]IndexInfo, error) {
+	var rows []struct {
+		Index     string  `json:"index"`
+		DocCount  *string `json:"docs.count"`
+		StoreSize *string `json:"store.size"`
+	}
+
+	// the sizes are returned in bytes rather than in a human readable form
+	if err := c.jsonRequest("GET", "/_cat/indices/"+c.indicesPattern(), "format=json&bytes=b&h=index,docs.count,store.size", "", &rows); err != nil {
+		return nil, err
+	}
+
+	indices := make([]IndexInfo, 0, len(rows))
+	for _, row := range rows {
+		info := IndexInfo{Name: row.Index}
+
+		// the counters of closed indices are null
+		var err error
+		if row.DocCount != nil {
+			if info.DocCount, err = strconv.ParseInt(*row.DocCount, 10, 64); err != nil {
+				return nil, fmt.Errorf("Invalid document count of index %s: %s", row.Index, err)
+			}
+		}
+		if row.StoreSize != nil {
+			if info.StoreSize, err = strconv.ParseInt(*row.StoreSize, 10, 64); err != nil {
+				return nil, fmt.Errorf("Invalid store size of index %s: %s", row.Index, err)
+			}
+		}
+
+		indices = append(indices, info)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i].Name < indices[j].Name })
+
+	return indices, nil
+}
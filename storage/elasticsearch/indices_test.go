@@ -0,0 +1,68 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package elasticsearch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestListIndices(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_cat/indices/skydive_v*" || r.URL.Query().Get("bytes") != "b" {
+			t.Errorf("Unexpected request %s?%s", r.URL.Path, r.URL.RawQuery)
+		}
+		w.Write([]byte(`[
+			{"index": "skydive_v3-2017.01.02", "docs.count": "1024", "store.size": "524288"},
+			{"index": "skydive_v3-2017.01.01", "docs.count": "12", "store.size": "8192"},
+			{"index": "skydive_v2", "docs.count": null, "store.size": null}
+		]`))
+	}))
+	defer server.Close()
+
+	indices, err := newTestClient(t, hostOf(server)).ListIndices()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []IndexInfo{
+		{Name: "skydive_v2"},
+		{Name: "skydive_v3-2017.01.01", DocCount: 12, StoreSize: 8192},
+		{Name: "skydive_v3-2017.01.02", DocCount: 1024, StoreSize: 524288},
+	}
+	if !reflect.DeepEqual(indices, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, indices)
+	}
+}
+
+func TestListIndicesPattern(t *testing.T) {
+	client := newTestClient(t, "127.0.0.1:9200")
+	client.SetIndexPerType(true)
+	client.types = mappingTypes([]map[string][]byte{{"flow": nil}, {"metric": nil}})
+
+	// the other deployments sharing the prefix, as skydive_staging, are left out
+	if pattern := client.indicesPattern(); pattern != "skydive_v*,skydive_flow_v*,skydive_metric_v*" {
+		t.Errorf("Wrong indices pattern: %s", pattern)
+	}
+}
@@ -0,0 +1,263 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	elastigo "github.com/lebauce/elastigo/lib"
+)
+
+// fieldTypes are the field types accepted by the mapping builder
+var fieldTypes = map[string]bool{
+	"keyword": true, "text": true, "string": true,
+	"long": true, "integer": true, "short": true, "byte": true,
+	"double": true, "float": true, "boolean": true,
+	"date": true, "ip": true, "geo_point": true,
+	"object": true, "nested": true,
+}
+
+// FieldOption sets a parameter of a mapped field
+type FieldOption func(field map[string]interface{})
+
+// WithFormat sets the format of a date field, epoch_second for instance
+func WithFormat(format string) FieldOption {
+	return func(field map[string]interface{}) {
+		field["format"] = format
+	}
+}
+
+// WithIndex sets whether the field is searchable
+func WithIndex(index bool) FieldOption {
+	return func(field map[string]interface{}) {
+		field["index"] = index
+	}
+}
+
+// WithDocValues sets whether the field can be sorted or aggregated on
+func WithDocValues(docValues bool) FieldOption {
+	return func(field map[string]interface{}) {
+		field["doc_values"] = docValues
+	}
+}
+
+// WithParameter sets any other parameter of the field
+func WithParameter(name string, value interface{}) FieldOption {
+	return func(field map[string]interface{}) {
+		field[name] = value
+	}
+}
+
+// Mapping builds the mapping of a document type, as passed to Start
+type Mapping struct {
+	properties map[string]interface{}
+	dynamic    string
+	err        error
+}
+
+// NewMapping returns an empty mapping
+func NewMapping() *Mapping {
+	return &Mapping{properties: make(map[string]interface{})}
+}
+
+// AddField adds a field to the mapping, a dotted name being mapped as the
+// properties of the parent objects. An invalid field is reported by JSON.
+func (m *Mapping) AddField(name string, esType string, opts ...FieldOption) *Mapping {
+	if m.err != nil {
+		return m
+	}
+
+	if !fieldTypes[esType] {
+		m.err = fmt.Errorf("Unknown type %s of field %s", esType, name)
+		return m
+	}
+
+	field := map[string]interface{}{"type": esType}
+	for _, opt := range opts {
+		opt(field)
+	}
+
+	properties := m.properties
+	path := strings.Split(name, ".")
+	for i, key := range path[:len(path)-1] {
+		parent, ok := properties[key].(map[string]interface{})
+		if !ok {
+			parent = map[string]interface{}{"properties": make(map[string]interface{})}
+			properties[key] = parent
+		}
+
+		if parentType, found := parent["type"]; found && parentType != "object" && parentType != "nested" {
+			m.err = fmt.Errorf("Field %s is not an object", strings.Join(path[:i+1], "."))
+			return m
+		}
+
+		if properties, ok = parent["properties"].(map[string]interface{}); !ok {
+			properties = make(map[string]interface{})
+			parent["properties"] = properties
+		}
+	}
+
+	if properties[path[len(path)-1]] != nil {
+		m.err = fmt.Errorf("Field %s already mapped", name)
+		return m
+	}
+	properties[path[len(path)-1]] = field
+
+	return m
+}
+
+// SetDynamic sets how the fields missing from the mapping are handled, true,
+// false or strict
+func (m *Mapping) SetDynamic(mode string) *Mapping {
+	if m.err != nil {
+		return m
+	}
+
+	if _, m.err = parseDynamic(mode); m.err == nil {
+		m.dynamic = mode
+	}
+	return m
+}
+
+// JSON returns the mapping as expected by Start, or the first error
+// encountered while adding the fields
+func (m *Mapping) JSON() ([]byte, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	mapping := map[string]interface{}{"properties": m.properties}
+	if m.dynamic != "" {
+		mapping["dynamic"] = m.dynamic
+	}
+	return json.Marshal(mapping)
+}
+
+// SetMappingCache enables the caching of the mappings returned by GetMapping,
+// they are then only fetched again after a call to RefreshMapping
+func (c *ElasticSearchClient) SetMappingCache(enabled bool) {
+	c.mappingLock.Lock()
+	defer c.mappingLock.Unlock()
+
+	if enabled {
+		c.mappingCache = make(map[string]map[string]interface{})
+	} else {
+		c.mappingCache = nil
+	}
+}
+
+// RefreshMapping invalidates the cached mapping of a document type
+func (c *ElasticSearchClient) RefreshMapping(obj string) {
+	c.mappingLock.Lock()
+	defer c.mappingLock.Unlock()
+
+	if c.mappingCache != nil {
+		delete(c.mappingCache, obj)
+	}
+}
+
+// GetMapping returns the current mapping of a document type, with
+// single-type indices the mapping holds the fields of all the types.
+// ErrNotFound is returned if the type has no mapping yet.
+func (c *ElasticSearchClient) GetMapping(obj string) (map[string]interface{}, error) {
+	c.mappingLock.Lock()
+	mapping, found := c.mappingCache[obj]
+	c.mappingLock.Unlock()
+
+	if found {
+		return mapping, nil
+	}
+
+	var response map[string]struct {
+		Mappings map[string]map[string]interface{} `json:"mappings"`
+	}
+
+	query := ""
+	if c.version >= 7 {
+		query = "include_type_name=true"
+	}
+
+	err := c.jsonRequest("GET", "/"+c.typeAlias(obj)+"/_mapping/"+c.docType(obj), query, "", &response)
+	if err == elastigo.RecordNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get the %s mapping: %s", obj, err.Error())
+	}
+
+	// the alias may span several indices, the newest has the current mapping.
+	// The indices are ordered by version, then by name for the daily ones.
+	alias := c.typeAlias(obj)
+	var indices []string
+	for index := range response {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		vi, _ := indexNameVersion(alias, indices[i])
+		vj, _ := indexNameVersion(alias, indices[j])
+		if vi != vj {
+			return vi < vj
+		}
+		return indices[i] < indices[j]
+	})
+
+	for _, index := range indices {
+		if m, found := response[index].Mappings[c.docType(obj)]; found {
+			mapping = m
+		}
+	}
+	if mapping == nil {
+		return nil, ErrNotFound
+	}
+
+	c.mappingLock.Lock()
+	if c.mappingCache != nil {
+		c.mappingCache[obj] = mapping
+	}
+	c.mappingLock.Unlock()
+
+	return mapping, nil
+}
+
+// HasField returns whether a field, dotted for the fields of objects, is
+// part of the mapping of a document type
+func (c *ElasticSearchClient) HasField(obj string, field string) (bool, error) {
+	mapping, err := c.GetMapping(obj)
+	if err != nil {
+		return false, err
+	}
+
+	for _, key := range strings.Split(field, ".") {
+		properties, ok := mapping["properties"].(map[string]interface{})
+		if !ok {
+			return false, nil
+		}
+		if mapping, ok = properties[key].(map[string]interface{}); !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestMapping(t *testing.T) {
+	mapping, err := NewMapping().
+		AddField("UUID", "keyword").
+		AddField("Description", "text").
+		AddField("CreatedAt", "date", WithFormat("epoch_second")).
+		AddField("Metric.RxBytes", "long").
+		AddField("Metric.TxBytes", "long", WithDocValues(false)).
+		AddField("Network.A", "ip").
+		AddField("Location", "geo_point").
+		JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expected, actual interface{}
+	json.Unmarshal([]byte(`{
+		"properties": {
+			"UUID": {"type": "keyword"},
+			"Description": {"type": "text"},
+			"CreatedAt": {"type": "date", "format": "epoch_second"},
+			"Metric": {"properties": {
+				"RxBytes": {"type": "long"},
+				"TxBytes": {"type": "long", "doc_values": false}
+			}},
+			"Network": {"properties": {
+				"A": {"type": "ip"}
+			}},
+			"Location": {"type": "geo_point"}
+		}
+	}`), &expected)
+	if err := json.Unmarshal(mapping, &actual); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected mapping %v, got %s", expected, string(mapping))
+	}
+
+	if err := validateMappings([]map[string][]byte{{"node": mapping}}); err != nil {
+		t.Errorf("Built mapping should be valid: %s", err)
+	}
+}
+
+func TestMappingErrors(t *testing.T) {
+	if _, err := NewMapping().AddField("UUID", "uuid").JSON(); err == nil {
+		t.Error("Expected an error for an unknown type")
+	}
+	if _, err := NewMapping().AddField("UUID", "keyword").AddField("UUID", "text").JSON(); err == nil {
+		t.Error("Expected an error for a field mapped twice")
+	}
+	if _, err := NewMapping().AddField("Metric", "long").AddField("Metric.RxBytes", "long").JSON(); err == nil {
+		t.Error("Expected an error for a field nested in a non object field")
+	}
+}
+
+func TestGetMapping(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/skydive/_mapping/node":
+			w.Write([]byte(`{"skydive_v3": {"mappings": {"node": {
+				"dynamic_templates": [],
+				"properties": {
+					"ID": {"type": "keyword"},
+					"Metadata": {"properties": {"Name": {"type": "keyword"}}}
+				}
+			}}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	client.SetMappingCache(true)
+
+	mapping, err := client.GetMapping("node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := mapping["properties"]; !found {
+		t.Errorf("Expected the node mapping, got %v", mapping)
+	}
+
+	for field, expected := range map[string]bool{"ID": true, "Metadata.Name": true, "Metadata.Type": false, "ID.Name": false} {
+		if found, err := client.HasField("node", field); err != nil || found != expected {
+			t.Errorf("Expected field %s to be found %v, got %v: %v", field, expected, found, err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Expected the mapping to be cached, got %d requests", requests)
+	}
+
+	client.RefreshMapping("node")
+	if _, err := client.GetMapping("node"); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected the mapping to be fetched again after a refresh, got %d requests", requests)
+	}
+
+	if _, err := client.GetMapping("edge"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a missing mapping, got %v", err)
+	}
+}
+
+func TestGetMappingNewestIndex(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{
+			"skydive_v3": {"mappings": {"node": {"properties": {"Version": {"type": "keyword"}}}}},
+			"skydive_v10": {"mappings": {"node": {"properties": {"Version": {"type": "long"}}}}},
+			"skydive_v9": {"mappings": {"node": {"properties": {"Version": {"type": "integer"}}}}}
+		}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+
+	mapping, err := client.GetMapping("node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	field := mapping["properties"].(map[string]interface{})["Version"].(map[string]interface{})
+	if field["type"] != "long" {
+		t.Errorf("Expected the mapping of the skydive_v10 index, got %v", mapping)
+	}
+}
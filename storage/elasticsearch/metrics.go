@@ -0,0 +1,51 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import "time"
+
+// MetricsHandler is notified of the duration of the Elasticsearch operations,
+// allowing them to be exported to a monitoring system. The operations are
+// reported whether they succeeded or failed, a failed search having no hits.
+type MetricsHandler interface {
+	OnIndex(duration time.Duration, err error)
+	OnSearch(duration time.Duration, hits int, err error)
+	OnBulk(duration time.Duration, err error)
+}
+
+type noopMetricsHandler struct{}
+
+func (h noopMetricsHandler) OnIndex(duration time.Duration, err error) {}
+
+func (h noopMetricsHandler) OnSearch(duration time.Duration, hits int, err error) {}
+
+func (h noopMetricsHandler) OnBulk(duration time.Duration, err error) {}
+
+// SetMetricsHandler sets the handler notified of the operation durations,
+// it has to be called before the client is started
+func (c *ElasticSearchClient) SetMetricsHandler(handler MetricsHandler) {
+	if handler == nil {
+		handler = noopMetricsHandler{}
+	}
+	c.metrics = handler
+}
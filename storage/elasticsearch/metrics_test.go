@@ -0,0 +1,93 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type recordingMetricsHandler struct {
+	searchDuration time.Duration
+	searchHits     int
+	searchErr      error
+	indexDuration  time.Duration
+	indexErr       error
+}
+
+func (h *recordingMetricsHandler) OnIndex(duration time.Duration, err error) {
+	h.indexDuration, h.indexErr = duration, err
+}
+
+func (h *recordingMetricsHandler) OnSearch(duration time.Duration, hits int, err error) {
+	h.searchDuration, h.searchHits, h.searchErr = duration, hits, err
+}
+
+func (h *recordingMetricsHandler) OnBulk(duration time.Duration, err error) {}
+
+func TestMetricsHandler(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		w.Write([]byte(`{"hits": {"total": 2, "hits": [{"_id": "aaa"}, {"_id": "bbb"}]}}`))
+	}))
+	defer server.Close()
+
+	handler := &recordingMetricsHandler{}
+	client := newTestClient(t, hostOf(server))
+	client.SetMetricsHandler(handler)
+
+	if _, err := client.Search("node", ""); err != nil {
+		t.Fatal(err)
+	}
+	if handler.searchDuration <= 0 || handler.searchHits != 2 || handler.searchErr != nil {
+		t.Errorf("Search metrics not recorded: %+v", handler)
+	}
+
+	client.Index("node", "aaa", map[string]string{"Name": "eth0"})
+	if handler.indexDuration <= 0 || handler.indexErr == nil {
+		t.Errorf("Index metrics not recorded: %+v", handler)
+	}
+}
+
+func TestMetricsHandlerSearchError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": {"type": "parsing_exception"}}`))
+	}))
+	defer server.Close()
+
+	handler := &recordingMetricsHandler{}
+	client := newTestClient(t, hostOf(server))
+	client.SetMetricsHandler(handler)
+
+	if _, err := client.Search("node", ""); err == nil {
+		t.Fatal("Expected the search to fail")
+	}
+	if handler.searchDuration <= 0 || handler.searchHits != 0 || handler.searchErr == nil {
+		t.Errorf("Failed search metrics not recorded: %+v", handler)
+	}
+}
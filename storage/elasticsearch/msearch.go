@@ -0,0 +1,119 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	elastigo "github.com/lebauce/elastigo/lib"
+)
+
+// MultiSearchError holds the errors of the queries of a multi search that
+// failed, indexed by the position of the query
+type MultiSearchError map[int]error
+
+func (m MultiSearchError) Error() string {
+	var indexes []int
+	for i := range m {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	var errs []string
+	for _, i := range indexes {
+		errs = append(errs, fmt.Sprintf("query %d: %s", i, m[i].Error()))
+	}
+	return "Multi search error, " + strings.Join(errs, ", ")
+}
+
+// MultiSearch runs several search queries in a single request, the results
+// are returned in the order of the queries. When some of the queries fail,
+// their result is left empty and a MultiSearchError is returned.
+func (c *ElasticSearchClient) MultiSearch(obj string, queries []string) ([]elastigo.SearchResult, error) {
+	var response struct {
+		Responses []json.RawMessage `json:"responses"`
+	}
+
+	if len(queries) == 0 {
+		return nil, nil
+	}
+
+	header, _ := json.Marshal(map[string]string{"index": c.typeAlias(obj), "type": c.docType(obj)})
+
+	var body bytes.Buffer
+	for i, query := range queries {
+		query, err := c.typedQuery(obj, query)
+		if err != nil {
+			return nil, fmt.Errorf("Query %d: %s", i, err.Error())
+		}
+		if query == "" {
+			query = "{}"
+		}
+
+		// each query has to fit on a single line
+		body.Write(header)
+		body.WriteByte('\n')
+		if err := json.Compact(&body, []byte(query)); err != nil {
+			return nil, fmt.Errorf("Query %d: %s", i, err.Error())
+		}
+		body.WriteByte('\n')
+	}
+
+	if err := c.jsonRequest("POST", "/_msearch", c.searchParams(""), body.String(), &response); err != nil {
+		return nil, err
+	}
+
+	if len(response.Responses) != len(queries) {
+		return nil, fmt.Errorf("Expected %d search responses, got %d", len(queries), len(response.Responses))
+	}
+
+	results := make([]elastigo.SearchResult, len(queries))
+	errs := make(MultiSearchError)
+	for i, data := range response.Responses {
+		var failure struct {
+			Error  json.RawMessage `json:"error"`
+			Status int             `json:"status"`
+		}
+		if err := json.Unmarshal(data, &failure); err != nil {
+			errs[i] = err
+			continue
+		}
+		if failure.Error != nil {
+			errs[i] = newResponseError(failure.Status, failure.Error)
+			continue
+		}
+
+		if err := json.Unmarshal(data, &results[i]); err != nil {
+			errs[i] = err
+		}
+	}
+
+	if len(errs) > 0 {
+		return results, errs
+	}
+	return results, nil
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMultiSearch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_msearch" {
+			t.Errorf("Wrong multi search path: %s", r.URL.Path)
+		}
+
+		var lines []map[string]interface{}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var line map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				t.Errorf("Invalid multi search line %s: %s", scanner.Text(), err.Error())
+			}
+			lines = append(lines, line)
+		}
+
+		if len(lines) != 4 {
+			t.Fatalf("Expected 4 multi search lines, got %d", len(lines))
+		}
+		if lines[0]["index"] != "skydive" || lines[0]["type"] != "node" {
+			t.Errorf("Wrong multi search header: %v", lines[0])
+		}
+		if _, ok := lines[3]["query"]; !ok {
+			t.Errorf("Wrong second query: %v", lines[3])
+		}
+
+		w.Write([]byte(`{"responses": [
+			{"hits": {"total": 1, "hits": [{"_id": "aaa"}]}},
+			{"error": {"type": "query_parsing_exception", "reason": "failed"}, "status": 400}
+		]}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	results, err := client.MultiSearch("node", []string{
+		`{"query": {"term": {"Type": "netns"}}}`,
+		`{
+			"query": {"term": {"Type": "veth"}}
+		}`,
+	})
+
+	errs, ok := err.(MultiSearchError)
+	if !ok {
+		t.Fatalf("Expected a MultiSearchError, got: %v", err)
+	}
+	if len(errs) != 1 || errs[1] == nil {
+		t.Errorf("Expected the second query to fail, got: %v", errs)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+	if len(results[0].Hits.Hits) != 1 || results[0].Hits.Hits[0].Id != "aaa" {
+		t.Errorf("Wrong first result: %+v", results[0])
+	}
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package elasticsearch
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// Options holds the settings of a client, the ones of the
+// storage.elasticsearch configuration section. DefaultOptions returns the
+// default settings to start from.
+type Options struct {
+	Hosts        []string
+	IndexPrefix  string
+	IndexVersion int
+	// Version is the major version of the cluster, 0 to detect it
+	Version int
+
+	Username string
+	Password string
+	// TLSConfig enables TLS when not nil
+	TLSConfig *tls.Config
+
+	MaxConns         int
+	MaxIdleConns     int
+	MaxConnsPerHost  int
+	CompressRequests bool
+	MaxAttempts      int
+	RetryTimeout     time.Duration
+	RequestTimeout   time.Duration
+	OpaqueID         string
+	MaxRetryDelay    time.Duration
+	ConnectTimeout   time.Duration
+	HealthStatus     string
+	HealthTimeout    time.Duration
+
+	BulkMaxDocs       int
+	BulkMaxBytes      int
+	BulkFlushInterval time.Duration
+	BulkMaxFailures   int
+	BulkMaxRetries    int
+	BulkRetryDelay    time.Duration
+	DrainTimeout      time.Duration
+	MaxPending        int
+	PendingPolicy     string
+	BreakerThreshold  int
+	BreakerWindow     time.Duration
+	BreakerCooldown   time.Duration
+
+	Shards        int
+	Replicas      int
+	Codec         string
+	Analysis      string
+	AllowScripts  bool
+	KeywordFields []string
+	IndexTemplate bool
+	RollingIndex  bool
+	IndexPerType  bool
+
+	// DynamicMapping overrides the dynamic setting of the mappings per
+	// document type
+	DynamicMapping map[string]string
+}
+
+// DefaultOptions returns the default settings of a client connecting to a
+// local cluster
+func DefaultOptions() Options {
+	return Options{
+		Hosts:       []string{"127.0.0.1:9200"},
+		IndexPrefix: defaultIndexPrefix,
+
+		MaxConns:      10,
+		MaxIdleConns:  100,
+		MaxAttempts:   3,
+		RetryTimeout:  60 * time.Second,
+		MaxRetryDelay: 30 * time.Second,
+		HealthStatus:  "yellow",
+		HealthTimeout: 30 * time.Second,
+
+		BulkMaxFailures: 5,
+		BulkMaxRetries:  3,
+		BulkRetryDelay:  time.Second,
+		DrainTimeout:    5 * time.Second,
+		PendingPolicy:   PendingBlock,
+		BreakerWindow:   10 * time.Second,
+		BreakerCooldown: 30 * time.Second,
+
+		Replicas: -1,
+		Codec:    "default",
+	}
+}
+
+// NewElasticSearchClientFromOpts creates a client from the given settings
+func NewElasticSearchClientFromOpts(opts Options) (*ElasticSearchClient, error) {
+	if (opts.Username == "") != (opts.Password == "") {
+		return nil, ErrBadAuthConfig
+	}
+
+	retrySeconds := int(opts.RetryTimeout / time.Second)
+	client, err := NewElasticSearchClient(opts.Hosts, opts.MaxConns, retrySeconds, opts.BulkMaxDocs, opts.BulkMaxBytes, opts.BulkFlushInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	client.SetIndex(opts.IndexPrefix, opts.IndexVersion)
+	if err := client.SetVersion(opts.Version); err != nil {
+		return nil, err
+	}
+
+	client.SetCredentials(opts.Username, opts.Password)
+	if opts.TLSConfig != nil {
+		client.EnableTLS(opts.TLSConfig)
+	}
+
+	client.SetCompressRequests(opts.CompressRequests)
+	client.SetConnectionLimits(opts.MaxIdleConns, opts.MaxConnsPerHost)
+	client.SetRequestRetry(opts.MaxAttempts, opts.RetryTimeout)
+	client.SetRequestTimeout(opts.RequestTimeout)
+	client.SetOpaqueID(opts.OpaqueID)
+	client.SetStartRetry(opts.MaxRetryDelay, opts.ConnectTimeout)
+	if err := client.SetWaitForStatus(opts.HealthStatus, opts.HealthTimeout); err != nil {
+		return nil, err
+	}
+
+	client.SetBulkMaxFailures(opts.BulkMaxFailures)
+	client.SetBulkRetry(opts.BulkMaxRetries, opts.BulkRetryDelay)
+	client.SetDrainTimeout(opts.DrainTimeout)
+	if err := client.SetMaxPending(opts.MaxPending, opts.PendingPolicy); err != nil {
+		return nil, err
+	}
+	client.SetCircuitBreaker(opts.BreakerThreshold, opts.BreakerWindow, opts.BreakerCooldown)
+
+	client.SetIndexSettings(opts.Shards, opts.Replicas)
+	if err := client.SetCodec(opts.Codec); err != nil {
+		return nil, err
+	}
+	if err := client.SetAnalysis(opts.Analysis); err != nil {
+		return nil, err
+	}
+	client.SetAllowScripts(opts.AllowScripts)
+	client.SetKeywordFields(opts.KeywordFields...)
+	for obj, mode := range opts.DynamicMapping {
+		if err := client.SetDynamic(obj, mode); err != nil {
+			return nil, err
+		}
+	}
+	client.SetUseTemplate(opts.IndexTemplate)
+	client.SetRollingIndex(opts.RollingIndex)
+	client.SetIndexPerType(opts.IndexPerType)
+
+	return client, nil
+}
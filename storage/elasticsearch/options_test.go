@@ -0,0 +1,84 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package elasticsearch
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewElasticSearchClientFromOpts(t *testing.T) {
+	var path, authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, authorization = r.URL.Path, r.Header.Get("Authorization")
+		w.Write([]byte(`{"hits": {"total": 0, "hits": []}}`))
+	}))
+	defer server.Close()
+
+	opts := DefaultOptions()
+	opts.Hosts = []string{hostOf(server)}
+	opts.IndexPrefix = "flows"
+	opts.Version = 6
+	opts.Username, opts.Password = "skydive", "secret"
+	opts.Replicas = 0
+	opts.Codec = "best_compression"
+
+	client, err := NewElasticSearchClientFromOpts(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if client.AliasName() != "flows" || client.IndexName() != "flows_v3" {
+		t.Errorf("Expected the flows index, got %s and %s", client.AliasName(), client.IndexName())
+	}
+	if settings := client.settings(); settings["number_of_replicas"] != 0 || settings["codec"] != "best_compression" {
+		t.Errorf("Expected the index settings to be applied, got %v", settings)
+	}
+
+	if _, err := client.Search("node", ""); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/flows/_doc/_search" {
+		t.Errorf("Expected a single type search on the flows alias, got %s", path)
+	}
+	if expected := "Basic " + base64.StdEncoding.EncodeToString([]byte("skydive:secret")); authorization != expected {
+		t.Errorf("Expected Authorization header %s, got %s", expected, authorization)
+	}
+}
+
+func TestInvalidOpts(t *testing.T) {
+	for name, update := range map[string]func(*Options){
+		"no host":        func(opts *Options) { opts.Hosts = nil },
+		"no password":    func(opts *Options) { opts.Username = "skydive" },
+		"health status":  func(opts *Options) { opts.HealthStatus = "blue" },
+		"pending policy": func(opts *Options) { opts.MaxPending, opts.PendingPolicy = 10, "drop_newest" },
+		"codec":          func(opts *Options) { opts.Codec = "lz4" },
+	} {
+		opts := DefaultOptions()
+		update(&opts)
+		if _, err := NewElasticSearchClientFromOpts(opts); err == nil {
+			t.Errorf("Expected an error for an invalid %s", name)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	percolatorType  = "percolator"
+	percolatorField = "query"
+)
+
+// PercolatorMapping returns the mapping of the registered queries, to be
+// added to the mappings given to Start in order to use the percolation with
+// Elasticsearch 5 and above. Older versions don't need any mapping.
+func (c *ElasticSearchClient) PercolatorMapping() map[string][]byte {
+	if c.legacyPercolator() {
+		return map[string][]byte{}
+	}
+	return map[string][]byte{
+		percolatorType: []byte(`{"properties": {"` + percolatorField + `": {"type": "percolator"}}}`),
+	}
+}
+
+// legacyPercolator returns whether the queries are registered in the
+// .percolator type, replaced by the percolate query in Elasticsearch 5
+func (c *ElasticSearchClient) legacyPercolator() bool {
+	return c.version < 5
+}
+
+// RegisterPercolatorQuery registers the query of a search request, the
+// documents matching it being then reported by Percolate with its id
+func (c *ElasticSearchClient) RegisterPercolatorQuery(id string, query string) error {
+	var request map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(query), &request); err != nil {
+		return fmt.Errorf("Unable to parse query: %s", err.Error())
+	}
+	if _, found := request["query"]; !found {
+		return fmt.Errorf("No query to register for %s", id)
+	}
+
+	doc := map[string]json.RawMessage{percolatorField: request["query"]}
+	if !c.legacyPercolator() {
+		return c.Index(percolatorType, id, doc)
+	}
+
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return c.retryJSONRequest(context.Background(), "PUT", "/"+c.currentIndex(percolatorType)+"/.percolator/"+id, "", string(body), nil)
+}
+
+// Percolate returns the ids of the registered queries matching a document
+// of the given type
+func (c *ElasticSearchClient) Percolate(obj string, doc interface{}) ([]string, error) {
+	if c.legacyPercolator() {
+		var response struct {
+			Matches []struct {
+				ID string `json:"_id"`
+			} `json:"matches"`
+		}
+
+		body, err := json.Marshal(map[string]interface{}{"doc": doc})
+		if err != nil {
+			return nil, err
+		}
+		if err := c.jsonRequest("POST", c.docPath(obj)+"/_percolate", "", string(body), &response); err != nil {
+			return nil, err
+		}
+
+		ids := make([]string, len(response.Matches))
+		for i, match := range response.Matches {
+			ids[i] = match.ID
+		}
+		return ids, nil
+	}
+
+	percolate := map[string]interface{}{
+		"field":    percolatorField,
+		"document": doc,
+	}
+	// the type of the document is not needed with single-type indices
+	if !c.singleTypeIndex() {
+		percolate["document_type"] = c.docType(obj)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"query":   map[string]interface{}{"percolate": percolate},
+		"_source": false,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.Search(percolatorType, string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(result.Hits.Hits))
+	for i, hit := range result.Hits.Hits {
+		ids[i] = hit.Id
+	}
+	return ids, nil
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPercolate(t *testing.T) {
+	server := newRecordingServer(t)
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+	client.SetVersion(5)
+
+	if mapping := client.PercolatorMapping(); len(mapping[percolatorType]) == 0 {
+		t.Error("Expected a percolator mapping with Elasticsearch 5")
+	}
+
+	if err := client.RegisterPercolatorQuery("suspicious", `{"query": {"term": {"Transport.B": "23"}}}`); err != nil {
+		t.Fatal(err)
+	}
+
+	request := server.last(t)
+	if request.method != "PUT" || request.path != "/skydive/percolator/suspicious" {
+		t.Errorf("Wrong query registration: %s %s", request.method, request.path)
+	}
+	expected := map[string]interface{}{
+		"query": map[string]interface{}{"term": map[string]interface{}{"Transport.B": "23"}},
+	}
+	if !reflect.DeepEqual(request.body, expected) {
+		t.Errorf("Expected %v registered query, got %v", expected, request.body)
+	}
+
+	flow := map[string]interface{}{"Transport": map[string]interface{}{"B": "23"}}
+
+	server.respond(`{"hits": {"total": 1, "hits": [{"_id": "suspicious"}]}}`)
+	ids, err := client.Percolate("flow", flow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"suspicious"}) {
+		t.Errorf("Expected the suspicious query to match, got %v", ids)
+	}
+
+	request = server.last(t)
+	if request.path != "/skydive/percolator/_search" {
+		t.Errorf("Wrong percolate request: %s", request.path)
+	}
+	percolate := request.body["query"].(map[string]interface{})["percolate"].(map[string]interface{})
+	if percolate["field"] != "query" || percolate["document_type"] != "flow" || !reflect.DeepEqual(percolate["document"], flow) {
+		t.Errorf("Wrong percolate query: %v", percolate)
+	}
+
+	server.respond(`{"hits": {"total": 0, "hits": []}}`)
+	if ids, err = client.Percolate("flow", map[string]interface{}{"Transport": map[string]interface{}{"B": "80"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("Expected no query to match, got %v", ids)
+	}
+
+	if err := client.RegisterPercolatorQuery("invalid", `{"size": 10}`); err == nil {
+		t.Error("Expected an error without query")
+	}
+}
+
+func TestLegacyPercolate(t *testing.T) {
+	server := newRecordingServer(t)
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+
+	if err := client.RegisterPercolatorQuery("suspicious", `{"query": {"term": {"Transport.B": "23"}}}`); err != nil {
+		t.Fatal(err)
+	}
+	if request := server.last(t); request.method != "PUT" || request.path != "/skydive_v3/.percolator/suspicious" {
+		t.Errorf("Wrong query registration: %s %s", request.method, request.path)
+	}
+
+	server.respond(`{"total": 1, "matches": [{"_index": "skydive_v3", "_id": "suspicious"}]}`)
+	ids, err := client.Percolate("flow", map[string]interface{}{"Transport": map[string]interface{}{"B": "23"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"suspicious"}) {
+		t.Errorf("Expected the suspicious query to match, got %v", ids)
+	}
+
+	request := server.last(t)
+	if request.path != "/skydive/flow/_percolate" {
+		t.Errorf("Wrong percolate request: %s", request.path)
+	}
+	if _, found := request.body["doc"]; !found {
+		t.Errorf("Expected the document to percolate, got %v", request.body)
+	}
+
+	server.respond(`{"total": 0, "matches": []}`)
+	if ids, err = client.Percolate("flow", map[string]interface{}{}); err != nil || len(ids) != 0 {
+		t.Errorf("Expected no query to match, got %v: %v", ids, err)
+	}
+}
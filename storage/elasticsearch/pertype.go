@@ -0,0 +1,77 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package elasticsearch
+
+import (
+	"sort"
+	"strings"
+)
+
+// SetIndexPerType makes the client store each document type into its own
+// index, named after the type, so that the retention and the sharding can
+// be tuned per type. Each type gets its own alias as well, the documents of
+// different types can't be joined then.
+func (c *ElasticSearchClient) SetIndexPerType(enabled bool) {
+	c.indexPerType = enabled
+}
+
+// typeAlias returns the alias used to access the documents of the given type
+func (c *ElasticSearchClient) typeAlias(obj string) string {
+	if c.indexPerType {
+		return c.AliasName() + "_" + obj
+	}
+	return c.AliasName()
+}
+
+// typeIndex returns the versioned index holding the documents of the given
+// type
+func (c *ElasticSearchClient) typeIndex(obj string) string {
+	if c.indexPerType {
+		return c.typeAlias(obj) + strings.TrimPrefix(c.IndexName(), c.AliasName())
+	}
+	return c.IndexName()
+}
+
+// indices returns the indices created when the client starts
+func (c *ElasticSearchClient) indices() []string {
+	if !c.indexPerType {
+		return []string{c.currentIndex("")}
+	}
+
+	var indices []string
+	for _, obj := range c.types {
+		indices = append(indices, c.typeIndex(obj))
+	}
+	return indices
+}
+
+// mappingTypes returns the sorted document types of the mappings
+func mappingTypes(mappings []map[string][]byte) []string {
+	var types []string
+	for _, document := range mappings {
+		for obj := range document {
+			types = append(types, obj)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package elasticsearch
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestIndexPerTypeStart(t *testing.T) {
+	var lock sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		lock.Unlock()
+
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_open"):
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/_alias/"):
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Write([]byte(`{"status": "green"}`))
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	client.SetIndexPerType(true)
+
+	mappings := []map[string][]byte{
+		{"node": []byte(`{"properties": {}}`)},
+		{"flow": []byte(`{"properties": {}}`)},
+	}
+	if err := client.Start(mappings); err != nil {
+		t.Fatal(err)
+	}
+	client.Stop()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	received := strings.Join(requests, "\n")
+	for _, expected := range []string{
+		"PUT /skydive_flow_v3",
+		"PUT /skydive_node_v3",
+		"PUT /skydive_flow_v3/_mapping/flow",
+		"PUT /skydive_node_v3/_mapping/node",
+		"GET /_cluster/health/skydive_flow_v3,skydive_node_v3",
+		"GET /_alias/skydive_flow",
+		"GET /_alias/skydive_node",
+	} {
+		if !strings.Contains(received, expected+"\n") {
+			t.Errorf("Expected request %s, got:\n%s", expected, received)
+		}
+	}
+
+	if strings.Contains(received, "PUT /skydive_v3\n") {
+		t.Errorf("The shared index should not be created:\n%s", received)
+	}
+
+	client = newTestClient(t, hostOf(server))
+	client.SetIndexPerType(true)
+	client.SetUseTemplate(true)
+	if err := client.Start(mappings); !errors.Is(err, ErrIncompatibleOptions) {
+		t.Errorf("Expected ErrIncompatibleOptions as per type indices can't be used with a template, got: %v", err)
+	}
+
+	client = newTestClient(t, hostOf(server))
+	client.SetIndexPerType(true)
+	client.SetRollingIndex(true)
+	client.SetUseTemplate(false)
+	if err := client.Start(mappings); !errors.Is(err, ErrIncompatibleOptions) {
+		t.Errorf("Expected ErrIncompatibleOptions as per type indices can't be used with rolling indices, got: %v", err)
+	}
+
+	// a relation can't span several indices
+	client = newTestClient(t, hostOf(server))
+	client.SetIndexPerType(true)
+	err := client.Start([]map[string][]byte{
+		{"flow": []byte(`{"properties": {}}`)},
+		{"metric": []byte(`{"_parent": {"type": "flow"}}`)},
+	})
+	if !errors.Is(err, ErrIncompatibleOptions) {
+		t.Errorf("Expected ErrIncompatibleOptions for a parent/child mapping, got: %v", err)
+	}
+}
+
+func TestIndexPerType(t *testing.T) {
+	server := newRecordingServer(t)
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+	client.SetIndexPerType(true)
+
+	if index := client.typeIndex("flow"); index != "skydive_flow_v3" {
+		t.Errorf("Expected skydive_flow_v3 index, got %s", index)
+	}
+
+	if err := client.Index("flow", "flow-1", map[string]interface{}{"UUID": "flow-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if request := server.last(t); request.path != "/skydive_flow/flow/flow-1" {
+		t.Errorf("Wrong index request: %s", request.path)
+	}
+
+	if err := client.Index("node", "node-1", map[string]interface{}{"ID": "node-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if request := server.last(t); request.path != "/skydive_node/node/node-1" {
+		t.Errorf("Wrong index request: %s", request.path)
+	}
+
+	if _, err := client.Search("node", `{"query": {"match_all": {}}}`); err != nil {
+		t.Fatal(err)
+	}
+	if request := server.last(t); request.path != "/skydive_node/node/_search" {
+		t.Errorf("Wrong search request: %s", request.path)
+	}
+
+	server.respond(`{"_id": "flow-1", "_type": "flow", "found": true, "_source": {}}`)
+	if _, err := client.Get("flow", "flow-1"); err != nil {
+		t.Fatal(err)
+	}
+	if request := server.last(t); request.path != "/skydive_flow/flow/flow-1" {
+		t.Errorf("Wrong get request: %s", request.path)
+	}
+
+	server.respond(`{}`)
+	if _, err := client.Delete("node", "node-1"); err != nil {
+		t.Fatal(err)
+	}
+	if request := server.last(t); request.method != "DELETE" || request.path != "/skydive_node/node/node-1" {
+		t.Errorf("Wrong delete request: %s %s", request.method, request.path)
+	}
+
+	// single type indices hold the documents of one type only
+	client.SetVersion(6)
+	if err := client.Index("flow", "flow-2", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if request := server.last(t); request.path != "/skydive_flow/_doc/flow-2" {
+		t.Errorf("Wrong index request: %s", request.path)
+	}
+}
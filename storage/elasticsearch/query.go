@@ -0,0 +1,161 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"strings"
+
+	elastigo "github.com/lebauce/elastigo/lib"
+
+	"github.com/skydive-project/skydive/filters"
+)
+
+type querySort struct {
+	field string
+	order int
+}
+
+// Query builds the body of a search request
+type Query struct {
+	client *ElasticSearchClient
+	filter *filters.Filter
+	prefix string
+	from   int
+	size   int
+	sorts  []querySort
+}
+
+// NewQuery returns a query matching all the documents
+func (c *ElasticSearchClient) NewQuery() *Query {
+	return &Query{client: c, from: -1, size: -1}
+}
+
+// WithFilter restricts the query to the documents matching the filter
+func (q *Query) WithFilter(filter *filters.Filter) *Query {
+	q.filter = filter
+	return q
+}
+
+// WithPrefix sets the prefix of the fields used by the filter
+func (q *Query) WithPrefix(prefix string) *Query {
+	q.prefix = prefix
+	return q
+}
+
+// WithFrom sets the offset of the first returned document
+func (q *Query) WithFrom(from int) *Query {
+	q.from = from
+	return q
+}
+
+// WithSize sets the maximum number of returned documents
+func (q *Query) WithSize(size int) *Query {
+	q.size = size
+	return q
+}
+
+// WithSort sorts the documents on the field, the sorts are applied in the
+// order they were added
+func (q *Query) WithSort(field string, order int) *Query {
+	q.sorts = append(q.sorts, querySort{field: field, order: order})
+	return q
+}
+
+// JSON renders the body of the query
+func (q *Query) JSON() (string, error) {
+	request := map[string]interface{}{
+		"query": q.client.FormatFilter(q.filter, q.prefix),
+	}
+
+	if q.from >= 0 {
+		request["from"] = q.from
+	}
+	if q.size >= 0 {
+		request["size"] = q.size
+	}
+
+	if len(q.sorts) > 0 {
+		var sorts []interface{}
+		for _, s := range q.sorts {
+			sort, err := q.client.FormatSort(s.field, s.order)
+			if err != nil {
+				return "", err
+			}
+			sorts = append(sorts, sort)
+		}
+		request["sort"] = sorts
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// SearchQuery runs a search using a query built with NewQuery
+func (c *ElasticSearchClient) SearchQuery(obj string, q *Query) (elastigo.SearchResult, error) {
+	body, err := q.JSON()
+	if err != nil {
+		return elastigo.SearchResult{}, err
+	}
+	return c.Search(obj, body)
+}
+
+// ValidateQuery checks whether a query is well formed without running it,
+// the explanation describes the query or the reason why it is invalid
+func (c *ElasticSearchClient) ValidateQuery(obj string, query string) (bool, string, error) {
+	var result struct {
+		Valid        bool   `json:"valid"`
+		Error        string `json:"error"`
+		Explanations []struct {
+			Valid       bool   `json:"valid"`
+			Explanation string `json:"explanation"`
+			Error       string `json:"error"`
+		} `json:"explanations"`
+	}
+
+	query, err := c.typedQuery(obj, query)
+	if err != nil {
+		return false, err.Error(), nil
+	}
+
+	if err := c.jsonRequest("POST", c.docPath(obj)+"/_validate/query", "explain=true", query, &result); err != nil {
+		return false, "", err
+	}
+
+	var explanations []string
+	if result.Error != "" {
+		explanations = append(explanations, result.Error)
+	}
+	for _, e := range result.Explanations {
+		if e.Error != "" {
+			explanations = append(explanations, e.Error)
+		} else if e.Explanation != "" {
+			explanations = append(explanations, e.Explanation)
+		}
+	}
+
+	return result.Valid, strings.Join(explanations, "\n"), nil
+}
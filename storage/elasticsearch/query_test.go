@@ -0,0 +1,110 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/skydive-project/skydive/filters"
+)
+
+func TestQuery(t *testing.T) {
+	client := newTestClient(t, "127.0.0.1:9200")
+
+	body, err := client.NewQuery().JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != `{"query":{"match_all":{}}}` {
+		t.Errorf("Expected a match all query, got %s", body)
+	}
+
+	body, err = client.NewQuery().
+		WithFilter(filters.NewTermStringFilter("Type", "netns")).
+		WithPrefix("Metadata.").
+		WithSize(20).
+		WithSort("Metadata.Name", AscendingOrder).
+		WithSort("CreatedAt", DescendingOrder).
+		JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result, expected map[string]interface{}
+	json.Unmarshal([]byte(body), &result)
+	json.Unmarshal([]byte(`{
+		"query": {"term": {"Metadata.Type": "netns"}},
+		"size": 20,
+		"sort": [{"Metadata.Name": {"order": "asc"}}, {"CreatedAt": {"order": "desc"}}]
+	}`), &expected)
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Wrong query body: %s", body)
+	}
+
+	if _, err := client.NewQuery().WithSort("CreatedAt", 42).JSON(); err != ErrBadSortOrder {
+		t.Errorf("Expected ErrBadSortOrder, got: %v", err)
+	}
+}
+
+func TestValidateQuery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/skydive/node/_validate/query" || r.URL.Query().Get("explain") != "true" {
+			t.Errorf("Wrong validate request: %s?%s", r.URL.Path, r.URL.RawQuery)
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), "unknown") {
+			w.Write([]byte(`{"valid": false, "explanations": [{"index": "skydive_v3", "valid": false,
+				"error": "no [query] registered for [unknown]"}]}`))
+			return
+		}
+		w.Write([]byte(`{"valid": true, "explanations": [{"index": "skydive_v3", "valid": true,
+			"explanation": "Type:netns"}]}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+
+	valid, explanation, err := client.ValidateQuery("node", `{"query": {"term": {"Type": "netns"}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !valid || explanation != "Type:netns" {
+		t.Errorf("Expected a valid query, got valid=%t: %s", valid, explanation)
+	}
+
+	valid, explanation, err = client.ValidateQuery("node", `{"query": {"unknown": {}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid || !strings.Contains(explanation, "unknown") {
+		t.Errorf("Expected an invalid query, got valid=%t: %s", valid, explanation)
+	}
+}
@@ -0,0 +1,192 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package elasticsearch
+
+import (
+	"fmt"
+	"sync"
+
+	elastigo "github.com/lebauce/elastigo/lib"
+
+	"github.com/skydive-project/skydive/logging"
+)
+
+// Policies applied when the queue of pending documents is full
+const (
+	// PendingBlock makes the callers wait for the queue to have room
+	PendingBlock = "block"
+	// PendingDropOldest drops the oldest pending document to enqueue the new
+	// one, the dropped documents being counted
+	PendingDropOldest = "drop_oldest"
+)
+
+// bulkOp adds a document operation to the bulk indexer
+type bulkOp func(indexer *elastigo.BulkIndexer) error
+
+// pendingQueue bounds the number of documents waiting to be added to the bulk
+// indexer, which would otherwise grow as long as the cluster is too slow
+type pendingQueue struct {
+	sync.Mutex
+	changed  *sync.Cond
+	ops      []bulkOp
+	capacity int
+	policy   string
+	dropped  int64
+	busy     bool
+	closed   bool
+}
+
+func newPendingQueue(capacity int, policy string) *pendingQueue {
+	q := &pendingQueue{capacity: capacity, policy: policy}
+	q.changed = sync.NewCond(&q.Mutex)
+	return q
+}
+
+// push enqueues an operation, applying the policy of the queue when full
+func (q *pendingQueue) push(op bulkOp) error {
+	q.Lock()
+	defer q.Unlock()
+
+	for !q.closed && len(q.ops) >= q.capacity {
+		if q.policy == PendingDropOldest {
+			q.ops = q.ops[1:]
+			q.dropped++
+			break
+		}
+		q.changed.Wait()
+	}
+
+	if q.closed {
+		return ErrStopped
+	}
+
+	q.ops = append(q.ops, op)
+	q.changed.Broadcast()
+	return nil
+}
+
+// pop returns the oldest operation, waiting for one to be enqueued, it
+// returns false once the queue is closed
+func (q *pendingQueue) pop() (bulkOp, bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	for !q.closed && len(q.ops) == 0 {
+		q.changed.Wait()
+	}
+	if q.closed {
+		return nil, false
+	}
+
+	op := q.ops[0]
+	q.ops = q.ops[1:]
+	q.busy = true
+	q.changed.Broadcast()
+	return op, true
+}
+
+// done reports the operation returned by pop as added to the indexer
+func (q *pendingQueue) done() {
+	q.Lock()
+	q.busy = false
+	q.changed.Broadcast()
+	q.Unlock()
+}
+
+// wait waits for all the operations to be added to the indexer
+func (q *pendingQueue) wait() {
+	q.Lock()
+	defer q.Unlock()
+
+	for !q.closed && (len(q.ops) > 0 || q.busy) {
+		q.changed.Wait()
+	}
+}
+
+// close releases the blocked callers, the pending operations are dropped
+func (q *pendingQueue) close() int {
+	q.Lock()
+	defer q.Unlock()
+
+	pending := len(q.ops)
+	q.dropped += int64(pending)
+	q.ops = nil
+	q.closed = true
+	q.changed.Broadcast()
+	return pending
+}
+
+func (q *pendingQueue) len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.ops)
+}
+
+// SetMaxPending bounds the number of documents waiting to be added to the
+// bulk indexer, 0 meaning no bound. When the queue is full, the callers
+// either block or the oldest document is dropped, depending on the policy.
+func (c *ElasticSearchClient) SetMaxPending(capacity int, policy string) error {
+	if policy != PendingBlock && policy != PendingDropOldest {
+		return fmt.Errorf("Invalid pending policy %s, has to be %s or %s", policy, PendingBlock, PendingDropOldest)
+	}
+
+	c.maxPending = capacity
+	c.pendingPolicy = policy
+	return nil
+}
+
+// DroppedDocs returns the number of documents dropped as the queue of
+// pending documents was full or because the client was stopped
+func (c *ElasticSearchClient) DroppedDocs() int64 {
+	if c.pending == nil {
+		return 0
+	}
+
+	c.pending.Lock()
+	defer c.pending.Unlock()
+	return c.pending.dropped
+}
+
+// enqueue adds an operation to the bulk indexer, through the queue of
+// pending documents if bounded
+func (c *ElasticSearchClient) enqueue(op bulkOp) error {
+	if c.pending == nil {
+		return op(c.bulkIndexer())
+	}
+	return c.pending.push(op)
+}
+
+// consumePending adds the pending operations to the bulk indexer until the
+// queue is closed
+func (c *ElasticSearchClient) consumePending(q *pendingQueue) {
+	for {
+		op, ok := q.pop()
+		if !ok {
+			return
+		}
+
+		if err := op(c.bulkIndexer()); err != nil {
+			logging.GetLogger().Errorf("Unable to add a pending document to the bulk indexer: %s", err.Error())
+		}
+		q.done()
+	}
+}
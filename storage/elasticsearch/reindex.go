@@ -0,0 +1,121 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ReindexStatus holds the progress of a reindex task
+type ReindexStatus struct {
+	Completed bool
+	Total     int64
+	Created   int64
+	Updated   int64
+	Deleted   int64
+	// Failures holds the reasons of the documents that couldn't be copied
+	Failures []string
+	// Error is set when the task itself failed
+	Error string
+}
+
+// Reindex copies the documents of an index into another one, typically
+// when migrating to a new index version. The copy is done in the background
+// by the cluster, the returned task id is used to follow its progress with
+// ReindexStatus.
+func (c *ElasticSearchClient) Reindex(sourceIndex string, destIndex string) (string, error) {
+	if sourceIndex == "" || destIndex == "" || sourceIndex == destIndex {
+		return "", fmt.Errorf("Invalid reindex from '%s' to '%s'", sourceIndex, destIndex)
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"source": map[string]string{"index": sourceIndex},
+		"dest":   map[string]string{"index": destIndex},
+	})
+
+	var response struct {
+		Task string `json:"task"`
+	}
+	if err := c.jsonRequest("POST", "/_reindex", "wait_for_completion=false", string(body), &response); err != nil {
+		return "", fmt.Errorf("Unable to reindex %s into %s: %s", sourceIndex, destIndex, err.Error())
+	}
+
+	if response.Task == "" {
+		return "", errors.New("No task returned by the reindex request")
+	}
+	return response.Task, nil
+}
+
+// ReindexStatus returns the progress of a reindex task
+func (c *ElasticSearchClient) ReindexStatus(taskID string) (*ReindexStatus, error) {
+	var response struct {
+		Completed bool `json:"completed"`
+		Task      struct {
+			Status struct {
+				Total   int64 `json:"total"`
+				Created int64 `json:"created"`
+				Updated int64 `json:"updated"`
+				Deleted int64 `json:"deleted"`
+			} `json:"status"`
+		} `json:"task"`
+		Error *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+		Response struct {
+			Failures []struct {
+				ID    string `json:"id"`
+				Cause struct {
+					Reason string `json:"reason"`
+				} `json:"cause"`
+			} `json:"failures"`
+		} `json:"response"`
+	}
+
+	if strings.TrimSpace(taskID) == "" {
+		return nil, errors.New("Empty reindex task id")
+	}
+
+	if err := c.jsonRequest("GET", "/_tasks/"+taskID, "", "", &response); err != nil {
+		return nil, fmt.Errorf("Unable to get the status of the reindex task %s: %s", taskID, err.Error())
+	}
+
+	status := response.Task.Status
+	result := &ReindexStatus{
+		Completed: response.Completed,
+		Total:     status.Total,
+		Created:   status.Created,
+		Updated:   status.Updated,
+		Deleted:   status.Deleted,
+	}
+	if response.Error != nil {
+		result.Error = response.Error.Type + ": " + response.Error.Reason
+	}
+	for _, failure := range response.Response.Failures {
+		result.Failures = append(result.Failures, failure.ID+": "+failure.Cause.Reason)
+	}
+	return result, nil
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReindex(t *testing.T) {
+	server := newRecordingServer(t)
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+
+	server.respond(`{"task": "oTUltX4IQMOUUVeiohTt8A:12345"}`)
+	task, err := client.Reindex("skydive_v3", "skydive_v4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task != "oTUltX4IQMOUUVeiohTt8A:12345" {
+		t.Errorf("Wrong reindex task: %s", task)
+	}
+
+	request := server.last(t)
+	if request.method != "POST" || request.path != "/_reindex" || request.query != "wait_for_completion=false" {
+		t.Errorf("Wrong reindex request: %s %s?%s", request.method, request.path, request.query)
+	}
+	expected := map[string]interface{}{
+		"source": map[string]interface{}{"index": "skydive_v3"},
+		"dest":   map[string]interface{}{"index": "skydive_v4"},
+	}
+	if !reflect.DeepEqual(request.body, expected) {
+		t.Errorf("Expected %v reindex, got %v", expected, request.body)
+	}
+
+	if _, err := client.Reindex("skydive_v3", "skydive_v3"); err == nil {
+		t.Error("Expected an error when reindexing an index into itself")
+	}
+}
+
+func TestReindexStatus(t *testing.T) {
+	server := newRecordingServer(t)
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+
+	server.respond(`{"completed": false, "task": {"status": {"total": 100, "created": 40, "updated": 2, "deleted": 0}}}`)
+	status, err := client.ReindexStatus("oTUltX4IQMOUUVeiohTt8A:12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request := server.last(t); request.method != "GET" || request.path != "/_tasks/oTUltX4IQMOUUVeiohTt8A:12345" {
+		t.Errorf("Wrong task request: %s %s", request.method, request.path)
+	}
+
+	expected := &ReindexStatus{Total: 100, Created: 40, Updated: 2}
+	if !reflect.DeepEqual(status, expected) {
+		t.Errorf("Expected %+v status, got %+v", expected, status)
+	}
+
+	server.respond(`{
+		"completed": true,
+		"task": {"status": {"total": 100, "created": 99}},
+		"response": {"failures": [{"id": "aaa", "cause": {"type": "mapper_parsing_exception", "reason": "failed to parse"}}]}
+	}`)
+	if status, err = client.ReindexStatus("oTUltX4IQMOUUVeiohTt8A:12345"); err != nil {
+		t.Fatal(err)
+	}
+	if !status.Completed || status.Created != 99 || !reflect.DeepEqual(status.Failures, []string{"aaa: failed to parse"}) {
+		t.Errorf("Wrong completed status: %+v", status)
+	}
+
+	server.respond(`{"completed": true, "task": {"status": {}}, "error": {"type": "index_not_found_exception", "reason": "no such index"}}`)
+	if status, err = client.ReindexStatus("oTUltX4IQMOUUVeiohTt8A:12345"); err != nil {
+		t.Fatal(err)
+	}
+	if status.Error != "index_not_found_exception: no such index" {
+		t.Errorf("Wrong task error: %s", status.Error)
+	}
+}
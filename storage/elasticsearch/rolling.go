@@ -0,0 +1,201 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	elastigo "github.com/lebauce/elastigo/lib"
+)
+
+// rollingDateFormat is the date suffix format of the daily indices
+const rollingDateFormat = "2006.01.02"
+
+// SetRollingIndex makes the client write the documents into daily indices,
+// named after the versioned index and the day, the alias spanning all of
+// them. The mappings are then set through an index template so that the
+// indices get them when created. Parent and child documents have to be
+// indexed the same day to be joined.
+func (c *ElasticSearchClient) SetRollingIndex(enabled bool) {
+	c.rolling = enabled
+	if enabled {
+		c.useTemplate = true
+	}
+}
+
+// dailyIndex returns the name of the index holding the documents of the day
+func (c *ElasticSearchClient) dailyIndex(t time.Time) string {
+	return c.IndexName() + "-" + t.UTC().Format(rollingDateFormat)
+}
+
+// currentIndex returns the name of the index the documents of the given
+// type are written to
+func (c *ElasticSearchClient) currentIndex(obj string) string {
+	if c.rolling {
+		if index := c.rolledIndex(); index != "" {
+			return index
+		}
+		return c.dailyIndex(time.Now())
+	}
+	return c.typeIndex(obj)
+}
+
+// indexPattern returns the pattern matching all the indices of the client
+func (c *ElasticSearchClient) indexPattern() string {
+	if c.rolling {
+		return c.IndexName() + "-*"
+	}
+	if c.indexPerType {
+		return strings.Join(c.indices(), ",")
+	}
+	return c.IndexName()
+}
+
+// writePath returns the path where the documents of the given type are
+// indexed, the alias can't be written to when spanning several indices
+func (c *ElasticSearchClient) writePath(obj string) string {
+	if c.rolling {
+		return "/" + c.currentIndex(obj) + "/" + c.docType(obj)
+	}
+	return c.docPath(obj)
+}
+
+// documentPath returns the path of an existing document, looking for the
+// index holding it when using daily indices. The indices of today and of
+// yesterday are looked up first with real-time gets, the search only seeing
+// the documents once the index is refreshed.
+func (c *ElasticSearchClient) documentPath(obj string, id string) (string, error) {
+	if !c.rolling {
+		return c.docPath(obj) + "/" + id, nil
+	}
+
+	now := time.Now()
+	indices := []string{c.dailyIndex(now), c.dailyIndex(now.AddDate(0, 0, -1))}
+	if index := c.rolledIndex(); index != "" {
+		indices = append([]string{index}, indices...)
+	}
+
+	for _, index := range indices {
+		path := "/" + index + "/" + c.docType(obj) + "/" + id
+
+		var resp struct {
+			Found bool `json:"found"`
+		}
+		err := c.jsonRequest("GET", path, "_source=false", "", &resp)
+		if err == nil && resp.Found {
+			return path, nil
+		}
+		if err != nil && err != elastigo.RecordNotFound {
+			return "", err
+		}
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{
+			"ids": map[string][]string{"values": {id}},
+		},
+		"size": 1,
+	})
+
+	result, err := c.Search(obj, string(body))
+	if err != nil {
+		return "", err
+	}
+	if len(result.Hits.Hits) == 0 {
+		return "", elastigo.RecordNotFound
+	}
+	return "/" + result.Hits.Hits[0].Index + "/" + c.docType(obj) + "/" + id, nil
+}
+
+// multiGetSearch retrieves several documents using a search as the multi get
+// API doesn't support an alias spanning several indices
+func (c *ElasticSearchClient) multiGetSearch(obj string, ids []string) ([]elastigo.BaseResponse, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{
+			"ids": map[string][]string{"values": ids},
+		},
+		"size": len(ids),
+	})
+
+	result, err := c.Search(obj, string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make(map[string]elastigo.Hit)
+	for _, hit := range result.Hits.Hits {
+		hits[hit.Id] = hit
+	}
+
+	docs := make([]elastigo.BaseResponse, len(ids))
+	for i, id := range ids {
+		docs[i].Id = id
+		if hit, found := hits[id]; found {
+			docs[i].Index, docs[i].Type, docs[i].Source, docs[i].Found = hit.Index, obj, hit.Source, true
+		}
+	}
+	return docs, nil
+}
+
+// DropIndex deletes the daily indices only holding documents older than the
+// given time
+func (c *ElasticSearchClient) DropIndex(olderThan time.Time) error {
+	var indices map[string]interface{}
+
+	if !c.rolling {
+		return fmt.Errorf("Daily indices not enabled")
+	}
+
+	if err := c.jsonRequest("GET", "/"+c.indexPattern()+"/_aliases", "", "", &indices); err != nil {
+		return err
+	}
+
+	var dropped []string
+	for index := range indices {
+		// the rolled over indices are suffixed by a sequence number
+		suffix := strings.TrimPrefix(index, c.IndexName()+"-")
+		if i := strings.Index(suffix, "-"); i >= 0 {
+			suffix = suffix[:i]
+		}
+
+		day, err := time.Parse(rollingDateFormat, suffix)
+		if err != nil || !strings.HasPrefix(index, c.IndexName()+"-") {
+			continue
+		}
+
+		if !day.AddDate(0, 0, 1).After(olderThan) {
+			dropped = append(dropped, index)
+		}
+	}
+
+	if len(dropped) == 0 {
+		return nil
+	}
+	sort.Strings(dropped)
+
+	return c.jsonRequest("DELETE", "/"+strings.Join(dropped, ","), "", "", nil)
+}
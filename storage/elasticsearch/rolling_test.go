@@ -0,0 +1,143 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDailyIndex(t *testing.T) {
+	client := newTestClient(t, "127.0.0.1:9200")
+	client.SetRollingIndex(true)
+
+	day := time.Date(2017, time.March, 5, 23, 30, 0, 0, time.UTC)
+	if index := client.dailyIndex(day); index != "skydive_v3-2017.03.05" {
+		t.Errorf("Expected skydive_v3-2017.03.05 index, got %s", index)
+	}
+
+	// days are in UTC
+	local := time.Date(2017, time.March, 6, 1, 30, 0, 0, time.FixedZone("CET", 3600))
+	if index := client.dailyIndex(local); index != "skydive_v3-2017.03.06" {
+		t.Errorf("Expected skydive_v3-2017.03.06 index, got %s", index)
+	}
+
+	if pattern := client.indexPattern(); pattern != "skydive_v3-*" {
+		t.Errorf("Expected skydive_v3-* pattern, got %s", pattern)
+	}
+	if !client.useTemplate {
+		t.Error("Daily indices require an index template")
+	}
+}
+
+func TestDropIndex(t *testing.T) {
+	var dropped string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			if r.URL.Path != "/skydive_v3-*/_aliases" {
+				t.Errorf("Wrong indices request: %s", r.URL.Path)
+			}
+			w.Write([]byte(`{
+				"skydive_v3-2017.03.03": {"aliases": {"skydive": {}}},
+				"skydive_v3-2017.03.03-000001": {"aliases": {"skydive": {}}},
+				"skydive_v3-2017.03.04": {"aliases": {"skydive": {}}},
+				"skydive_v3-2017.03.05": {"aliases": {"skydive": {}}},
+				"skydive_v3-backup": {"aliases": {}}
+			}`))
+		case "DELETE":
+			dropped = r.URL.Path
+			w.Write([]byte(`{"acknowledged": true}`))
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	if err := client.DropIndex(time.Now()); err == nil {
+		t.Error("Expected an error when daily indices are not enabled")
+	}
+
+	client.SetRollingIndex(true)
+	if err := client.DropIndex(time.Date(2017, time.March, 5, 12, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	if dropped != "/skydive_v3-2017.03.03,skydive_v3-2017.03.03-000001,skydive_v3-2017.03.04" {
+		t.Errorf("Expected the indices older than the cutoff to be dropped, got %s", dropped)
+	}
+}
+
+func TestRollingDocumentPath(t *testing.T) {
+	var lock sync.Mutex
+	var searches int
+
+	client := newTestClient(t, "127.0.0.1:9200")
+	today := client.dailyIndex(time.Now())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/skydive/flow/_search":
+			lock.Lock()
+			searches++
+			lock.Unlock()
+			w.Write([]byte(`{"hits": {"total": 1, "hits": [{"_index": "skydive_v3-2017.03.05", "_type": "flow", "_id": "old"}]}}`))
+		case r.Method == "GET" && r.URL.Path == "/"+today+"/flow/fresh":
+			w.Write([]byte(`{"_index": "` + today + `", "_id": "fresh", "found": true}`))
+		case r.Method == "GET":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"found": false}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	client = newTestClient(t, hostOf(server))
+	client.SetRollingIndex(true)
+
+	// a document indexed today is found before the index is refreshed
+	path, err := client.documentPath("flow", "fresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/"+today+"/flow/fresh" {
+		t.Errorf("Wrong document path: %s", path)
+	}
+	if searches != 0 {
+		t.Errorf("Expected no search for a document of today, got %d", searches)
+	}
+
+	path, err = client.documentPath("flow", "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/skydive_v3-2017.03.05/flow/old" {
+		t.Errorf("Wrong document path: %s", path)
+	}
+	if searches != 1 {
+		t.Errorf("Expected older documents to be searched, got %d searches", searches)
+	}
+}
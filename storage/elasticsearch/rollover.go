@@ -0,0 +1,133 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package elasticsearch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// writeAlias returns the alias pointing to the index the documents are
+// written to once rolled over
+func (c *ElasticSearchClient) writeAlias() string {
+	return c.AliasName() + "-write"
+}
+
+// rolledIndex returns the index the daily index of today was rolled over
+// to, an empty string if it was not
+func (c *ElasticSearchClient) rolledIndex() string {
+	index, _ := c.rolled.Load().(string)
+	if !strings.HasPrefix(index, c.dailyIndex(time.Now())+"-") {
+		return ""
+	}
+	return index
+}
+
+// nextRolledIndex returns the name of the index following the given one,
+// the daily index of the day suffixed by a sequence number
+func (c *ElasticSearchClient) nextRolledIndex(index string) string {
+	day := c.dailyIndex(time.Now())
+
+	n, _ := strconv.Atoi(strings.TrimPrefix(index, day+"-"))
+	return fmt.Sprintf("%s-%06d", day, n+1)
+}
+
+// loadRolledIndex retrieves the index the write alias points to, for the
+// documents to keep being written to it after a restart
+func (c *ElasticSearchClient) loadRolledIndex() error {
+	code, data, err := c.request("GET", "/_alias/"+c.writeAlias(), "", "")
+	if err != nil {
+		return err
+	}
+	if code == http.StatusNotFound {
+		return nil
+	}
+
+	var indices map[string]interface{}
+	if err := json.Unmarshal(data, &indices); err != nil {
+		return errors.New("Unable to parse aliases: " + err.Error())
+	}
+	for index := range indices {
+		c.rolled.Store(index)
+	}
+	return nil
+}
+
+// MaybeRollover rolls over the index the documents are written to once it
+// is older than maxAge, holds more than maxDocs documents or is larger than
+// maxSize, as 5gb. The zero values disable a condition. The new index is
+// named after the daily index suffixed by a sequence number, the write alias
+// being moved to it. Only available with daily indices, the new index
+// getting the mappings and the alias from the index template.
+func (c *ElasticSearchClient) MaybeRollover(maxAge time.Duration, maxDocs int64, maxSize string) (rolled bool, err error) {
+	if !c.rolling {
+		return false, fmt.Errorf("Daily indices not enabled")
+	}
+
+	conditions := make(map[string]interface{})
+	if maxAge > 0 {
+		conditions["max_age"] = fmt.Sprintf("%ds", int64(maxAge/time.Second))
+	}
+	if maxDocs > 0 {
+		conditions["max_docs"] = maxDocs
+	}
+	if maxSize != "" {
+		conditions["max_size"] = maxSize
+	}
+	if len(conditions) == 0 {
+		return false, fmt.Errorf("No rollover condition given")
+	}
+
+	// the write alias stays on the index of the previous day until the
+	// first rollover of the day, the index of the day being created if
+	// nothing was written to it yet
+	current := c.currentIndex("")
+	if c.rolledIndex() == "" {
+		if err := c.openIndex(current); err != nil {
+			return false, err
+		}
+		if err := c.swapAlias(c.writeAlias(), current); err != nil {
+			return false, err
+		}
+	}
+
+	var result struct {
+		NewIndex   string `json:"new_index"`
+		RolledOver bool   `json:"rolled_over"`
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{"conditions": conditions})
+	path := "/" + c.writeAlias() + "/_rollover/" + c.nextRolledIndex(current)
+	if err := c.jsonRequest("POST", path, "", string(body), &result); err != nil {
+		return false, err
+	}
+
+	if result.RolledOver {
+		c.rolled.Store(result.NewIndex)
+	}
+	return result.RolledOver, nil
+}
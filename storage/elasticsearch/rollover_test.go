@@ -0,0 +1,134 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+package elasticsearch
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMaybeRollover(t *testing.T) {
+	var lock sync.Mutex
+	var rollovers, aliases, created []string
+	var conditions map[string]interface{}
+	rolled := false
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		body, _ := ioutil.ReadAll(r.Body)
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/_alias/skydive-write":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{}`))
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/_open"):
+			// nothing was written today yet
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{}`))
+		case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/skydive_v"):
+			created = append(created, strings.TrimPrefix(r.URL.Path, "/"))
+			w.Write([]byte(`{"acknowledged": true}`))
+		case r.Method == "POST" && r.URL.Path == "/_aliases":
+			if len(created) == 0 {
+				t.Error("The write alias was set before the index of the day was created")
+			}
+			aliases = append(aliases, string(body))
+			w.Write([]byte(`{"acknowledged": true}`))
+		case r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/skydive-write/_rollover/"):
+			newIndex := strings.TrimPrefix(r.URL.Path, "/skydive-write/_rollover/")
+			rollovers = append(rollovers, newIndex)
+
+			var request struct {
+				Conditions map[string]interface{} `json:"conditions"`
+			}
+			json.Unmarshal(body, &request)
+			conditions = request.Conditions
+
+			response, _ := json.Marshal(map[string]interface{}{"new_index": newIndex, "rolled_over": rolled})
+			w.Write(response)
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	if _, err := client.MaybeRollover(time.Hour, 0, ""); err == nil {
+		t.Error("Expected an error when daily indices are not enabled")
+	}
+
+	client.SetRollingIndex(true)
+	today := client.dailyIndex(time.Now())
+
+	// the conditions are not met yet
+	if rolled, err := client.MaybeRollover(24*time.Hour, 1000000, "5gb"); err != nil || rolled {
+		t.Fatalf("Expected no rollover, got %v", err)
+	}
+
+	lock.Lock()
+	expected := map[string]interface{}{"max_age": "86400s", "max_docs": float64(1000000), "max_size": "5gb"}
+	if !reflect.DeepEqual(conditions, expected) {
+		t.Errorf("Expected the conditions %v, got %v", expected, conditions)
+	}
+	if len(aliases) != 1 || !strings.Contains(aliases[0], `"add":{"alias":"skydive-write","index":"`+today+`"}`) {
+		t.Errorf("Expected the write alias to be set on today's index, got %v", aliases)
+	}
+	if !reflect.DeepEqual(created, []string{today}) {
+		t.Errorf("Expected today's index to be created, got %v", created)
+	}
+	rolled = true
+	lock.Unlock()
+
+	if index := client.currentIndex("node"); index != today {
+		t.Errorf("Expected the documents to be written to %s, got %s", today, index)
+	}
+
+	if rolled, err := client.MaybeRollover(0, 1000, ""); err != nil || !rolled {
+		t.Fatalf("Expected a rollover, got %v", err)
+	}
+	if index := client.currentIndex("node"); index != today+"-000001" {
+		t.Errorf("Expected the documents to be written to the new index, got %s", index)
+	}
+
+	// the write alias was moved by the rollover
+	if _, err := client.MaybeRollover(0, 1000, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if expected := []string{today + "-000001", today + "-000001", today + "-000002"}; !reflect.DeepEqual(rollovers, expected) {
+		t.Errorf("Expected the rollovers %v, got %v", expected, rollovers)
+	}
+	if len(aliases) != 2 {
+		t.Errorf("Expected the write alias to only be set before the first rollover of the day, got %v", aliases)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	elastigo "github.com/lebauce/elastigo/lib"
+)
+
+// scrollKeepAlive is the time Elasticsearch keeps the scroll context
+// between two batches
+const scrollKeepAlive = "1m"
+
+// ScrollIterator iterates over the batches of a scroll search
+type ScrollIterator struct {
+	client    *ElasticSearchClient
+	ctx       context.Context
+	obj       string
+	query     string
+	batchSize int
+	scrollID  string
+	started   bool
+	done      bool
+}
+
+func (s *ScrollIterator) next() (result elastigo.SearchResult, err error) {
+	if !s.started {
+		s.started = true
+
+		var body string
+		if body, err = s.client.typedQuery(s.obj, s.query); err != nil {
+			return
+		}
+
+		query := s.client.searchParams(fmt.Sprintf("scroll=%s&size=%d", scrollKeepAlive, s.batchSize))
+		result, err = s.client.searchRequest(s.ctx, s.client.docPath(s.obj)+"/_search", query, body)
+	} else {
+		body, _ := json.Marshal(map[string]string{
+			"scroll":    scrollKeepAlive,
+			"scroll_id": s.scrollID,
+		})
+		result, err = s.client.searchRequest(s.ctx, "/_search/scroll", s.client.searchParams(""), string(body))
+	}
+
+	if result.ScrollId != "" {
+		s.scrollID = result.ScrollId
+	}
+	return
+}
+
+// Next returns the next batch of results, io.EOF is returned once all the
+// results have been returned. The scroll context is cleared when the results
+// are exhausted or on error.
+func (s *ScrollIterator) Next() (elastigo.SearchResult, error) {
+	if s.done {
+		return elastigo.SearchResult{}, io.EOF
+	}
+
+	result, err := s.next()
+	if err != nil {
+		s.Close()
+		return elastigo.SearchResult{}, err
+	}
+
+	if len(result.Hits.Hits) == 0 {
+		s.Close()
+		return elastigo.SearchResult{}, io.EOF
+	}
+
+	// a partial batch is the last one, no need for another round trip
+	if len(result.Hits.Hits) < s.batchSize {
+		s.Close()
+	}
+
+	return result, nil
+}
+
+// Close clears the scroll context on the Elasticsearch side, it has to be
+// called when the iteration is stopped before the results are exhausted
+func (s *ScrollIterator) Close() error {
+	if s.done {
+		return nil
+	}
+	s.done = true
+
+	if s.scrollID == "" {
+		return nil
+	}
+
+	body, _ := json.Marshal(map[string][]string{
+		"scroll_id": {s.scrollID},
+	})
+
+	// use a fresh context as the scroll has to be cleared even if the
+	// iteration was cancelled
+	return s.client.jsonRequest("DELETE", "/_search/scroll", "", string(body), nil)
+}
+
+// SearchScroll returns an iterator over the results of a query, fetching them
+// by batches of batchSize documents using the scroll API
+func (c *ElasticSearchClient) SearchScroll(obj string, query string, batchSize int) *ScrollIterator {
+	return c.SearchScrollWithContext(context.Background(), obj, query, batchSize)
+}
+
+// SearchScrollWithContext returns a scroll iterator, the iteration is aborted
+// when the context is cancelled
+func (c *ElasticSearchClient) SearchScrollWithContext(ctx context.Context, obj string, query string, batchSize int) *ScrollIterator {
+	return &ScrollIterator{
+		client:    c,
+		ctx:       ctx,
+		obj:       obj,
+		query:     query,
+		batchSize: batchSize,
+	}
+}
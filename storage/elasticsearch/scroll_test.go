@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSearchScroll(t *testing.T) {
+	batches := [][]string{{"a", "b"}, {"c", "d"}, {"e"}}
+
+	var cleared []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			ScrollID interface{} `json:"scroll_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		var batch int
+		switch {
+		case r.Method == "DELETE":
+			cleared = append(cleared, fmt.Sprintf("%v", body.ScrollID))
+			w.Write([]byte(`{"succeeded": true}`))
+			return
+		case r.URL.Path == "/_search/scroll":
+			fmt.Sscanf(body.ScrollID.(string), "scroll-%d", &batch)
+		}
+
+		var hits []map[string]string
+		for _, id := range batches[batch] {
+			hits = append(hits, map[string]string{"_id": id})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"_scroll_id": fmt.Sprintf("scroll-%d", batch+1),
+			"hits":       map[string]interface{}{"total": 5, "hits": hits},
+		})
+	}))
+	defer server.Close()
+
+	it := newTestClient(t, hostOf(server)).SearchScroll("flow", "", 2)
+
+	var ids []string
+	for {
+		result, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, hit := range result.Hits.Hits {
+			ids = append(ids, hit.Id)
+		}
+	}
+
+	if fmt.Sprintf("%v", ids) != "[a b c d e]" {
+		t.Errorf("Wrong documents returned: %v", ids)
+	}
+
+	if len(cleared) != 1 || cleared[0] != "[scroll-3]" {
+		t.Errorf("Scroll context should be cleared once, got: %v", cleared)
+	}
+}
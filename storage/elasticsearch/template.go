@@ -0,0 +1,105 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SetUseTemplate makes the client register an index template holding the
+// mappings and the settings, applied to the indices when they get created,
+// instead of putting the mappings each time the client is started. The
+// mappings of an existing index are left untouched, a new index version is
+// needed for mapping changes to be applied.
+func (c *ElasticSearchClient) SetUseTemplate(use bool) {
+	c.useTemplate = use
+}
+
+// templatePattern returns the pattern of the indices the template applies to
+func (c *ElasticSearchClient) templatePattern() string {
+	return c.AliasName() + "_v*"
+}
+
+// templateBody returns the index template built from the mappings and the
+// index settings
+func (c *ElasticSearchClient) templateBody(mappings []map[string][]byte) ([]byte, error) {
+	template := make(map[string]interface{})
+
+	// the pattern was renamed in Elasticsearch 6
+	if c.version >= 6 {
+		template["index_patterns"] = []string{c.templatePattern()}
+	} else {
+		template["template"] = c.templatePattern()
+	}
+
+	if settings := c.settings(); len(settings) > 0 {
+		template["settings"] = settings
+	}
+
+	// the daily indices are added to the alias when created
+	if c.rolling {
+		template["aliases"] = map[string]interface{}{c.AliasName(): map[string]interface{}{}}
+	}
+
+	mappings, err := c.typeMappings(mappings)
+	if err != nil {
+		return nil, err
+	}
+
+	types := make(map[string]json.RawMessage)
+	if c.singleTypeIndex() {
+		mapping, err := singleTypeMapping(mappings)
+		if err != nil {
+			return nil, err
+		}
+		types[singleType] = mapping
+	} else {
+		for _, document := range mappings {
+			for obj, mapping := range document {
+				types[obj] = mapping
+			}
+		}
+	}
+	template["mappings"] = types
+
+	return json.Marshal(template)
+}
+
+// putTemplate registers the index template
+func (c *ElasticSearchClient) putTemplate(mappings []map[string][]byte) error {
+	body, err := c.templateBody(mappings)
+	if err != nil {
+		return err
+	}
+
+	query := ""
+	if c.version >= 7 {
+		query = "include_type_name=true"
+	}
+
+	if err := c.jsonRequest("PUT", "/_template/"+c.AliasName(), query, string(body), nil); err != nil {
+		return fmt.Errorf("Unable to create the %s index template: %s", c.AliasName(), err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestIndexTemplate(t *testing.T) {
+	server := newRecordingServer(t)
+	server.respond(`{"status": "green"}`)
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+	client.SetUseTemplate(true)
+	client.SetIndexSettings(1, 0)
+
+	err := client.start([]map[string][]byte{
+		{"node": []byte(`{"properties": {"ID": {"type": "string"}}}`)},
+		{"edge": []byte(`{"properties": {"Parent": {"type": "string"}}}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Stop()
+
+	server.Lock()
+	defer server.Unlock()
+
+	var template *recordedRequest
+	for i, request := range server.requests {
+		if request.method == "PUT" && request.path == "/_template/skydive" {
+			template = &server.requests[i]
+		}
+		if request.method == "PUT" && request.path == "/skydive_v3/_mapping/node" {
+			t.Error("Mappings should not be put when using a template")
+		}
+	}
+	if template == nil {
+		t.Fatal("Index template not created")
+	}
+
+	var expected map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"template": "skydive_v*",
+		"settings": {"number_of_shards": 1, "number_of_replicas": 0},
+		"mappings": {
+			"node": {"properties": {"ID": {"type": "string"}}},
+			"edge": {"properties": {"Parent": {"type": "string"}}}
+		}
+	}`), &expected)
+	if !reflect.DeepEqual(template.body, expected) {
+		t.Errorf("Wrong index template: %v", template.body)
+	}
+}
@@ -0,0 +1,574 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	elastigo "github.com/lebauce/elastigo/lib"
+)
+
+// Starting with Elasticsearch 6, an index can only hold a single mapping type
+// and the parent/child relations are defined by a join field. In that mode
+// all the documents are stored under the singleType type, the skydive type of
+// a document being stored in the docTypeField field.
+const (
+	singleType   = "_doc"
+	docTypeField = "DocType"
+	docJoinField = "DocJoin"
+)
+
+// SetVersion sets the major version of the Elasticsearch cluster, versions
+// 6 and above use single-type indices, 0 keeps the legacy behaviour
+func (c *ElasticSearchClient) SetVersion(version int) error {
+	if version != 0 && (version < 2 || version > 7) {
+		return fmt.Errorf("Unsupported Elasticsearch version %d", version)
+	}
+
+	c.version = version
+	return nil
+}
+
+func (c *ElasticSearchClient) singleTypeIndex() bool {
+	return c.version >= 6
+}
+
+// docType returns the mapping type of the documents of the given type
+func (c *ElasticSearchClient) docType(obj string) string {
+	if c.singleTypeIndex() {
+		return singleType
+	}
+	return obj
+}
+
+// docPath returns the path of the documents of the given type
+func (c *ElasticSearchClient) docPath(obj string) string {
+	return "/" + c.typeAlias(obj) + "/" + c.docType(obj)
+}
+
+// searchParams adds to a search query string the parameters needed by the
+// cluster version, Elasticsearch 7 returns the hits total as an object
+// unless asked otherwise
+func (c *ElasticSearchClient) searchParams(query string) string {
+	if c.version < 7 {
+		return query
+	}
+
+	if query != "" {
+		query += "&"
+	}
+	return query + "rest_total_hits_as_int=true"
+}
+
+// TotalHits returns the number of documents matching a search, whether it
+// is a number as before Elasticsearch 7 or an object. The count is exact
+// unless the cluster stopped counting, the count being a lower bound then.
+func TotalHits(result elastigo.SearchResult) (int64, bool, error) {
+	if len(result.RawJSON) == 0 {
+		return int64(result.Hits.Total), true, nil
+	}
+
+	var response struct {
+		Hits struct {
+			Total json.RawMessage `json:"total"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(result.RawJSON, &response); err != nil {
+		return 0, false, fmt.Errorf("Unable to parse the search response: %s", err.Error())
+	}
+
+	var count int64
+	if err := json.Unmarshal(response.Hits.Total, &count); err == nil {
+		return count, true, nil
+	}
+
+	var total struct {
+		Value    int64  `json:"value"`
+		Relation string `json:"relation"`
+	}
+	if err := json.Unmarshal(response.Hits.Total, &total); err != nil {
+		return 0, false, fmt.Errorf("Invalid hits total %s", string(response.Hits.Total))
+	}
+	return total.Value, total.Relation != "gte", nil
+}
+
+// typedQuery restricts a query body to the documents of the given type when
+// using single-type indices
+func (c *ElasticSearchClient) typedQuery(obj string, query string) (string, error) {
+	if !c.singleTypeIndex() {
+		return query, nil
+	}
+
+	request := make(map[string]interface{})
+	if query != "" {
+		if err := json.Unmarshal([]byte(query), &request); err != nil {
+			return "", fmt.Errorf("Unable to parse query: %s", err.Error())
+		}
+	}
+
+	typed := map[string]interface{}{
+		"filter": map[string]interface{}{
+			"term": map[string]string{
+				docTypeField: obj,
+			},
+		},
+	}
+	if q, ok := request["query"]; ok {
+		typed["must"] = q
+	}
+	request["query"] = map[string]interface{}{"bool": typed}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// documentBody marshals a document, adding the type and the parent relation
+// fields when using single-type indices
+func (c *ElasticSearchClient) documentBody(obj string, parent string, data interface{}) ([]byte, error) {
+	body, err := json.Marshal(data)
+	if err != nil || !c.singleTypeIndex() {
+		return body, err
+	}
+
+	doc := make(map[string]interface{})
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("Unable to index %s, not a JSON object: %s", obj, err.Error())
+	}
+
+	doc[docTypeField] = obj
+	if parent != "" {
+		doc[docJoinField] = map[string]string{
+			"name":   obj,
+			"parent": parent,
+		}
+	} else if c.parentTypes[obj] {
+		// parents have to be part of the join as well to be matched by the
+		// has_child and has_parent queries
+		doc[docJoinField] = obj
+	}
+	return json.Marshal(doc)
+}
+
+// setHitRelations sets the type and the parent of the hits of single-type
+// indices from the type and join fields, as returned by the previous versions
+func (c *ElasticSearchClient) setHitRelations(hits []elastigo.Hit) {
+	if !c.singleTypeIndex() {
+		return
+	}
+
+	for i := range hits {
+		if hits[i].Source == nil {
+			continue
+		}
+
+		var doc struct {
+			DocType string          `json:"DocType"`
+			DocJoin json.RawMessage `json:"DocJoin"`
+		}
+		if json.Unmarshal(*hits[i].Source, &doc) != nil {
+			continue
+		}
+
+		if doc.DocType != "" {
+			hits[i].Type = doc.DocType
+		}
+
+		var join struct {
+			Parent string `json:"parent"`
+		}
+		if len(doc.DocJoin) > 0 && json.Unmarshal(doc.DocJoin, &join) == nil {
+			hits[i].Parent = join.Parent
+		}
+	}
+}
+
+// scriptSourceKey returns the key of the script source, named inline before
+// Elasticsearch 6
+func (c *ElasticSearchClient) scriptSourceKey() string {
+	if c.version >= 6 {
+		return "source"
+	}
+	return "inline"
+}
+
+// checkDocType reports documents of another type as not found when using
+// single-type indices, the response type is set to the document type
+func (c *ElasticSearchClient) checkDocType(obj string, resp *elastigo.BaseResponse) error {
+	if !c.singleTypeIndex() {
+		return nil
+	}
+
+	var doc struct {
+		DocType string `json:"DocType"`
+	}
+	if resp.Source != nil {
+		if err := json.Unmarshal(*resp.Source, &doc); err != nil {
+			return err
+		}
+	}
+
+	if doc.DocType != obj {
+		return elastigo.RecordNotFound
+	}
+	resp.Type = obj
+	return nil
+}
+
+// childQuery returns the query string used to index a child document
+func (c *ElasticSearchClient) childQuery(parent string, opts IndexChildOptions) (string, error) {
+	params := url.Values{}
+
+	routing := opts.Routing
+	if routing == "" {
+		routing = parent
+	}
+	params.Set("routing", routing)
+
+	if !c.singleTypeIndex() {
+		params.Set("parent", parent)
+	}
+
+	if !opts.Timestamp.IsZero() {
+		if c.singleTypeIndex() {
+			return "", fmt.Errorf("Document timestamp not supported by Elasticsearch %d", c.version)
+		}
+		params.Set("timestamp", strconv.FormatInt(opts.Timestamp.UnixNano()/int64(time.Millisecond), 10))
+	}
+
+	return params.Encode(), nil
+}
+
+// parentTypes returns the types declared as the parent of another type
+func parentTypes(mappings []map[string][]byte) map[string]bool {
+	parents := make(map[string]bool)
+	for _, document := range mappings {
+		for _, data := range document {
+			var mapping struct {
+				Parent *struct {
+					Type string `json:"type"`
+				} `json:"_parent"`
+			}
+			if json.Unmarshal(data, &mapping) == nil && mapping.Parent != nil {
+				parents[mapping.Parent.Type] = true
+			}
+		}
+	}
+	return parents
+}
+
+// singleTypeMapping merges the mappings of all the types into the mapping of
+// the single type, the _parent definitions being turned into the relations
+// of the join field. The most restrictive dynamic setting of the types
+// applies to all of them.
+func singleTypeMapping(mappings []map[string][]byte) ([]byte, error) {
+	var dynamic string
+	var templates []interface{}
+	templateNames := make(map[string]bool)
+	properties := map[string]interface{}{
+		docTypeField: map[string]string{"type": "keyword"},
+	}
+	relations := make(map[string][]string)
+
+	for _, document := range mappings {
+		for obj, data := range document {
+			var mapping struct {
+				Parent *struct {
+					Type string `json:"type"`
+				} `json:"_parent"`
+				Dynamic          interface{}              `json:"dynamic"`
+				DynamicTemplates []map[string]interface{} `json:"dynamic_templates"`
+				Properties       map[string]interface{}   `json:"properties"`
+			}
+			if err := json.Unmarshal(data, &mapping); err != nil {
+				return nil, fmt.Errorf("Unable to parse %s mapping: %s", obj, err.Error())
+			}
+
+			mode, err := parseDynamic(mapping.Dynamic)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s mapping: %s", obj, err.Error())
+			}
+			if mode != "" && (dynamic == "" || dynamicModes[mode] > dynamicModes[dynamic]) {
+				dynamic = mode
+			}
+
+			if mapping.Parent != nil {
+				relations[mapping.Parent.Type] = append(relations[mapping.Parent.Type], obj)
+			}
+
+			// templates are matched in order, the first definition wins
+			for _, template := range mapping.DynamicTemplates {
+				for name := range template {
+					if !templateNames[name] {
+						templateNames[name] = true
+						templates = append(templates, template)
+					}
+				}
+			}
+
+			for field, property := range mapping.Properties {
+				properties[field] = property
+			}
+		}
+	}
+
+	if len(relations) > 0 {
+		properties[docJoinField] = map[string]interface{}{
+			"type":      "join",
+			"relations": relations,
+		}
+	}
+
+	mapping := map[string]interface{}{"properties": properties}
+	if dynamic != "" {
+		mapping["dynamic"] = dynamic
+	}
+	if len(templates) > 0 {
+		mapping["dynamic_templates"] = templates
+	}
+	return json.Marshal(mapping)
+}
+
+// mappingError returns ErrMappingConflict when the mapping was rejected
+// because of the existing mapping of the index
+func mappingError(obj string, err error) error {
+	var esErr elastigo.ESError
+	if errors.As(err, &esErr) && esErr.Code == http.StatusBadRequest {
+		var response struct {
+			Error struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		}
+
+		if json.Unmarshal([]byte(esErr.What), &response) == nil {
+			switch response.Error.Type {
+			case "illegal_argument_exception", "merge_mapping_exception":
+				return fmt.Errorf("%w: %s mapping: %s", ErrMappingConflict, obj, response.Error.Reason)
+			}
+		}
+	}
+	return fmt.Errorf("Unable to create %s mapping: %s", obj, err.Error())
+}
+
+// indexNameVersion returns the version of one of the versioned indices of
+// the given alias, daily indices included
+func indexNameVersion(alias string, index string) (int, bool) {
+	prefix := alias + "_v"
+	if !strings.HasPrefix(index, prefix) {
+		return 0, false
+	}
+
+	version := strings.TrimPrefix(index, prefix)
+	if i := strings.Index(version, "-"); i >= 0 {
+		version = version[:i]
+	}
+
+	v, err := strconv.Atoi(version)
+	return v, err == nil
+}
+
+// checkNewerIndex returns ErrNewerIndexExists when an index with a version
+// higher than the one of the client exists, as after a downgrade
+func (c *ElasticSearchClient) checkNewerIndex() error {
+	if !c.indexPerType {
+		return c.checkNewerAliasIndex(c.AliasName(), c.IndexName())
+	}
+
+	for _, obj := range c.types {
+		if err := c.checkNewerAliasIndex(c.typeAlias(obj), c.typeIndex(obj)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ElasticSearchClient) checkNewerAliasIndex(alias string, index string) error {
+	var indices map[string]interface{}
+	if err := c.jsonRequest("GET", "/"+alias+"_v*/_aliases", "", "", &indices); err != nil {
+		if err == elastigo.RecordNotFound {
+			return nil
+		}
+		return fmt.Errorf("Unable to list the %s indices: %s", alias, err.Error())
+	}
+
+	current, _ := indexNameVersion(alias, index)
+
+	var newer []string
+	for name := range indices {
+		if version, ok := indexNameVersion(alias, name); ok && version > current {
+			newer = append(newer, name)
+		}
+	}
+
+	if len(newer) > 0 {
+		sort.Strings(newer)
+		return fmt.Errorf("%w: %s is newer than %s, migrate the data or set the index_version", ErrNewerIndexExists, strings.Join(newer, ", "), index)
+	}
+	return nil
+}
+
+// typeMappings returns the mappings as sent to the cluster, with the dynamic
+// settings set by SetDynamic and, starting with Elasticsearch 5, the string
+// fields turned into keyword or text fields
+func (c *ElasticSearchClient) typeMappings(mappings []map[string][]byte) ([]map[string][]byte, error) {
+	if len(c.dynamic) == 0 && c.version < 5 {
+		return mappings, nil
+	}
+
+	result := make([]map[string][]byte, 0, len(mappings))
+	for _, document := range mappings {
+		typed := make(map[string][]byte, len(document))
+		for obj, data := range document {
+			var mapping map[string]interface{}
+			if err := json.Unmarshal(data, &mapping); err != nil {
+				return nil, fmt.Errorf("Unable to parse %s mapping: %s", obj, err.Error())
+			}
+
+			if mode, found := c.dynamic[obj]; found {
+				mapping["dynamic"] = mode
+			}
+			if c.version >= 5 {
+				convertStringFields(mapping)
+			}
+
+			var err error
+			if typed[obj], err = json.Marshal(mapping); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, typed)
+	}
+	return result, nil
+}
+
+// convertStringFields replaces the string fields of a mapping, removed in
+// Elasticsearch 5, by keyword fields when not analyzed and by text fields
+// otherwise, the dynamic templates included
+func convertStringFields(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if v["type"] == "string" {
+			switch v["index"] {
+			case "not_analyzed":
+				v["type"] = "keyword"
+				delete(v, "index")
+			case "no":
+				v["type"] = "keyword"
+				v["index"] = false
+			default:
+				// text fields have no doc values
+				v["type"] = "text"
+				delete(v, "index")
+				delete(v, "doc_values")
+			}
+		}
+		for _, child := range v {
+			convertStringFields(child)
+		}
+	case []interface{}:
+		for _, child := range v {
+			convertStringFields(child)
+		}
+	}
+}
+
+// HasParentQuery returns a has_parent query matching the children of the
+// parent documents of the given type matched by query, the parent type
+// being named parent_type with single-type indices
+func (c *ElasticSearchClient) HasParentQuery(parent string, query interface{}) map[string]interface{} {
+	key := "type"
+	if c.singleTypeIndex() {
+		key = "parent_type"
+	}
+
+	return map[string]interface{}{
+		"has_parent": map[string]interface{}{
+			key:     parent,
+			"query": query,
+		},
+	}
+}
+
+// putMappings creates the mappings of the document types
+func (c *ElasticSearchClient) putMappings(mappings []map[string][]byte) error {
+	if !c.indexPerType {
+		return c.putIndexMappings(c.IndexName(), mappings)
+	}
+
+	for _, document := range mappings {
+		for obj, mapping := range document {
+			if err := c.putIndexMappings(c.typeIndex(obj), []map[string][]byte{{obj: mapping}}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// putIndexMappings creates the mappings of the document types in the given
+// index
+func (c *ElasticSearchClient) putIndexMappings(index string, mappings []map[string][]byte) error {
+	indexPath := "/" + index
+
+	mappings, err := c.typeMappings(mappings)
+	if err != nil {
+		return err
+	}
+
+	if !c.singleTypeIndex() {
+		for _, document := range mappings {
+			for obj, mapping := range document {
+				if err := c.jsonRequest("PUT", indexPath+"/_mapping/"+obj, "", string(mapping), nil); err != nil {
+					return mappingError(obj, err)
+				}
+			}
+		}
+		return nil
+	}
+
+	mapping, err := singleTypeMapping(mappings)
+	if err != nil {
+		return err
+	}
+
+	query := ""
+	if c.version >= 7 {
+		query = "include_type_name=true"
+	}
+
+	if err := c.jsonRequest("PUT", indexPath+"/_mapping/"+singleType, query, string(mapping), nil); err != nil {
+		return mappingError(singleType, err)
+	}
+	return nil
+}
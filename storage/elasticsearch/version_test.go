@@ -0,0 +1,446 @@
+/*
+ * Copyright (C) 2016 Red Hat, Inc.
+ *
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ *
+ */
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	elastigo "github.com/lebauce/elastigo/lib"
+)
+
+type recordedRequest struct {
+	method string
+	path   string
+	query  string
+	body   map[string]interface{}
+}
+
+type recordingServer struct {
+	sync.Mutex
+	*httptest.Server
+	requests []recordedRequest
+	response string
+}
+
+func (r *recordingServer) last(t *testing.T) recordedRequest {
+	r.Lock()
+	defer r.Unlock()
+
+	if len(r.requests) == 0 {
+		t.Fatal("No request received")
+	}
+	return r.requests[len(r.requests)-1]
+}
+
+func (r *recordingServer) respond(response string) {
+	r.Lock()
+	r.response = response
+	r.Unlock()
+}
+
+func newRecordingServer(t *testing.T) *recordingServer {
+	r := &recordingServer{response: `{}`}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		request := recordedRequest{method: req.Method, path: req.URL.Path, query: req.URL.RawQuery}
+		if data, _ := ioutil.ReadAll(req.Body); len(data) > 0 {
+			if err := json.Unmarshal(data, &request.body); err != nil {
+				t.Errorf("Invalid request body %s: %s", string(data), err.Error())
+			}
+		}
+
+		r.Lock()
+		defer r.Unlock()
+
+		r.requests = append(r.requests, request)
+		w.Write([]byte(r.response))
+	}))
+	return r
+}
+
+func TestNewerIndexExists(t *testing.T) {
+	var created []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/skydive_v*/_aliases":
+			w.Write([]byte(`{"skydive_v3": {"aliases": {}}, "skydive_v4": {"aliases": {"skydive": {}}}, "skydive_vm": {"aliases": {}}}`))
+		case r.Method == "PUT":
+			created = append(created, r.URL.Path)
+			w.Write([]byte(`{}`))
+		default:
+			w.Write([]byte(`{"status": "green"}`))
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server))
+	err := client.Start([]map[string][]byte{{"node": []byte(`{"properties": {}}`)}})
+	if !errors.Is(err, ErrNewerIndexExists) {
+		t.Fatalf("Expected ErrNewerIndexExists, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "skydive_v4") {
+		t.Errorf("Expected the error to name the newer index, got: %s", err.Error())
+	}
+	if len(created) != 0 {
+		t.Errorf("No index should be created, got %v", created)
+	}
+
+	// the newer index is used when configured
+	client = newTestClient(t, hostOf(server))
+	client.SetIndex("skydive", 4)
+	if err := client.Start([]map[string][]byte{{"node": []byte(`{"properties": {}}`)}}); err != nil {
+		t.Fatal(err)
+	}
+	client.Stop()
+}
+
+func TestLegacyIndex(t *testing.T) {
+	server := newRecordingServer(t)
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+
+	if err := client.IndexChild("metric", "flow-1", "", map[string]interface{}{"RxBytes": 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	request := server.last(t)
+	if request.path != "/skydive/metric" || request.query != "parent=flow-1&routing=flow-1" {
+		t.Errorf("Wrong child index request: %s?%s", request.path, request.query)
+	}
+	if _, ok := request.body[docTypeField]; ok {
+		t.Errorf("Legacy documents should not hold a type field: %v", request.body)
+	}
+
+	if _, err := client.Search("flow", `{"query": {"match_all": {}}}`); err != nil {
+		t.Fatal(err)
+	}
+
+	request = server.last(t)
+	if request.path != "/skydive/flow/_search" || request.query != "" {
+		t.Errorf("Wrong search request: %s?%s", request.path, request.query)
+	}
+	if !reflect.DeepEqual(request.body, map[string]interface{}{"query": map[string]interface{}{"match_all": map[string]interface{}{}}}) {
+		t.Errorf("Legacy queries should not be modified: %v", request.body)
+	}
+}
+
+func TestSingleTypeIndex(t *testing.T) {
+	server := newRecordingServer(t)
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+	if err := client.SetVersion(7); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Index("flow", "flow-1", map[string]interface{}{"UUID": "flow-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	request := server.last(t)
+	if request.method != "PUT" || request.path != "/skydive/_doc/flow-1" {
+		t.Errorf("Wrong index request: %s %s", request.method, request.path)
+	}
+	if request.body[docTypeField] != "flow" {
+		t.Errorf("Document type field not set: %v", request.body)
+	}
+
+	if err := client.IndexChild("metric", "flow-1", "", map[string]interface{}{"RxBytes": 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	request = server.last(t)
+	if request.path != "/skydive/_doc" || request.query != "routing=flow-1" {
+		t.Errorf("Wrong child index request: %s?%s", request.path, request.query)
+	}
+	expectedJoin := map[string]interface{}{"name": "metric", "parent": "flow-1"}
+	if !reflect.DeepEqual(request.body[docJoinField], expectedJoin) {
+		t.Errorf("Wrong join field: %v", request.body[docJoinField])
+	}
+
+	if _, err := client.Search("flow", `{"query": {"term": {"UUID": "flow-1"}}, "size": 5}`); err != nil {
+		t.Fatal(err)
+	}
+
+	request = server.last(t)
+	if request.path != "/skydive/_doc/_search" || request.query != "rest_total_hits_as_int=true" {
+		t.Errorf("Wrong search request: %s?%s", request.path, request.query)
+	}
+
+	var expected map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"query": {"bool": {
+			"filter": {"term": {"DocType": "flow"}},
+			"must": {"term": {"UUID": "flow-1"}}
+		}},
+		"size": 5
+	}`), &expected)
+	if !reflect.DeepEqual(request.body, expected) {
+		t.Errorf("Search query not restricted to the document type: %v", request.body)
+	}
+
+	server.respond(`{"_id": "flow-1", "_type": "_doc", "found": true, "_source": {"DocType": "metric"}}`)
+	if _, err := client.Get("flow", "flow-1"); err != ErrNotFound {
+		t.Errorf("Expected a document of another type to be not found, got: %v", err)
+	}
+
+	server.respond(`{"_id": "flow-1", "_type": "_doc", "found": true, "_source": {"DocType": "flow"}}`)
+	resp, err := client.Get("flow", "flow-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Type != "flow" {
+		t.Errorf("Expected the document type to be flow, got %s", resp.Type)
+	}
+
+	server.respond(`{}`)
+	if _, err := client.Delete("flow", "flow-1"); err != nil {
+		t.Fatal(err)
+	}
+	if request = server.last(t); request.path != "/skydive/_doc/flow-1" {
+		t.Errorf("Wrong delete request: %s", request.path)
+	}
+}
+
+func TestSingleTypeParentChild(t *testing.T) {
+	server := newRecordingServer(t)
+	server.respond(`{"status": "green"}`)
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+	if err := client.SetVersion(6); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Start([]map[string][]byte{
+		{"metric": []byte(`{"_parent": {"type": "flow"}}`)},
+		{"flow": []byte(`{"properties": {}}`)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+
+	if err := client.Index("flow", "flow-1", map[string]interface{}{"UUID": "flow-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if request := server.last(t); request.body[docJoinField] != "flow" {
+		t.Errorf("Parent documents should be part of the join: %v", request.body)
+	}
+
+	if err := client.IndexChild("metric", "flow-1", "", map[string]interface{}{"RxBytes": 10}); err != nil {
+		t.Fatal(err)
+	}
+	expectedJoin := map[string]interface{}{"name": "metric", "parent": "flow-1"}
+	if request := server.last(t); !reflect.DeepEqual(request.body[docJoinField], expectedJoin) {
+		t.Errorf("Wrong join field: %v", request.body[docJoinField])
+	}
+
+	server.respond(`{"hits": {"total": 2, "hits": [
+		{"_id": "m1", "_type": "_doc", "_source": {"DocType": "metric", "DocJoin": {"name": "metric", "parent": "flow-1"}}},
+		{"_id": "flow-1", "_type": "_doc", "_source": {"DocType": "flow", "DocJoin": "flow"}}
+	]}}`)
+	result, err := client.Search("metric", `{"query": {"match_all": {}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hits := result.Hits.Hits
+	if len(hits) != 2 {
+		t.Fatalf("Expected 2 hits, got %d", len(hits))
+	}
+	if hits[0].Parent != "flow-1" || hits[0].Type != "metric" {
+		t.Errorf("Wrong child hit parent %s and type %s", hits[0].Parent, hits[0].Type)
+	}
+	if hits[1].Parent != "" || hits[1].Type != "flow" {
+		t.Errorf("Wrong parent hit parent %s and type %s", hits[1].Parent, hits[1].Type)
+	}
+}
+
+func TestTotalHits(t *testing.T) {
+	server := newRecordingServer(t)
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+
+	for response, expected := range map[string]struct {
+		count int64
+		exact bool
+	}{
+		`{"hits": {"total": 42, "hits": []}}`:                                         {42, true},
+		`{"hits": {"total": {"value": 42, "relation": "eq"}, "hits": []}}`:            {42, true},
+		`{"hits": {"total": {"value": 10000, "relation": "gte"}, "hits": []}}`:        {10000, false},
+		`{"hits": {"total": {"value": 1, "relation": "eq"}, "hits": [{"_id": "a"}]}}`: {1, true},
+	} {
+		server.respond(response)
+
+		result, err := client.Search("flow", `{"query": {"match_all": {}}}`)
+		if err != nil {
+			t.Fatalf("Search failed for %s: %s", response, err.Error())
+		}
+
+		count, exact, err := TotalHits(result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != expected.count || exact != expected.exact {
+			t.Errorf("Expected %d hits, exact %v for %s, got %d, %v", expected.count, expected.exact, response, count, exact)
+		}
+	}
+
+	server.respond(`{"hits": {"total": {"value": 1, "relation": "eq"}, "hits": [{"_id": "a"}]}}`)
+	if result, _ := client.Search("flow", ""); len(result.Hits.Hits) != 1 || result.Hits.Hits[0].Id != "a" {
+		t.Errorf("The hits should be decoded with an object total: %v", result.Hits.Hits)
+	}
+
+	if count, exact, err := TotalHits(elastigo.SearchResult{Hits: elastigo.Hits{Total: 3}}); count != 3 || !exact || err != nil {
+		t.Errorf("Expected the decoded total to be used, got %d, %v, %v", count, exact, err)
+	}
+}
+
+func TestSingleTypeMapping(t *testing.T) {
+	mapping, err := singleTypeMapping([]map[string][]byte{
+		{"flow": []byte(`{"dynamic_templates": [{"bytes": {"match": "*Bytes"}}], "properties": {"UUID": {"type": "keyword"}}}`)},
+		{"metric": []byte(`{"_parent": {"type": "flow"}, "dynamic_templates": [{"bytes": {"match": "*Bytes"}}, {"start": {"match": "Start"}}]}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result, expected map[string]interface{}
+	json.Unmarshal(mapping, &result)
+	json.Unmarshal([]byte(`{
+		"dynamic_templates": [{"bytes": {"match": "*Bytes"}}, {"start": {"match": "Start"}}],
+		"properties": {
+			"DocType": {"type": "keyword"},
+			"DocJoin": {"type": "join", "relations": {"flow": ["metric"]}},
+			"UUID": {"type": "keyword"}
+		}
+	}`), &expected)
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Wrong single type mapping: %s", string(mapping))
+	}
+
+	if err := newTestClient(t, "127.0.0.1:9200").SetVersion(8); err == nil {
+		t.Error("Expected an error for an unsupported version")
+	}
+}
+
+func TestStringFieldsConversion(t *testing.T) {
+	mappings := []map[string][]byte{
+		{"flow": []byte(`{
+			"dynamic_templates": [{"strings": {"match": "*", "match_mapping_type": "string", "mapping": {"type": "string", "index": "not_analyzed"}}}],
+			"properties": {
+				"UUID": {"type": "string", "index": "not_analyzed"},
+				"Link": {"properties": {"A": {"type": "string", "index": "not_analyzed", "doc_values": false}}},
+				"Description": {"type": "string", "doc_values": false},
+				"Payload": {"type": "string", "index": "no"}
+			}
+		}`)},
+	}
+
+	client := newTestClient(t, "127.0.0.1:9200")
+	if err := client.SetVersion(7); err != nil {
+		t.Fatal(err)
+	}
+
+	converted, err := client.typeMappings(mappings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result, expected map[string]interface{}
+	json.Unmarshal(converted[0]["flow"], &result)
+	json.Unmarshal([]byte(`{
+		"dynamic_templates": [{"strings": {"match": "*", "match_mapping_type": "string", "mapping": {"type": "keyword"}}}],
+		"properties": {
+			"UUID": {"type": "keyword"},
+			"Link": {"properties": {"A": {"type": "keyword", "doc_values": false}}},
+			"Description": {"type": "text"},
+			"Payload": {"type": "keyword", "index": false}
+		}
+	}`), &expected)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Wrong converted mapping: %s", string(converted[0]["flow"]))
+	}
+
+	// the string fields are kept by the previous versions
+	client.SetVersion(2)
+	if converted, _ = client.typeMappings(mappings); string(converted[0]["flow"]) != string(mappings[0]["flow"]) {
+		t.Errorf("The mapping should not be converted for Elasticsearch 2: %s", string(converted[0]["flow"]))
+	}
+}
+
+func TestHasParentQuery(t *testing.T) {
+	client := newTestClient(t, "127.0.0.1:9200")
+	query := map[string]interface{}{"match_all": map[string]interface{}{}}
+
+	expected := map[string]interface{}{"has_parent": map[string]interface{}{"type": "flow", "query": query}}
+	if q := client.HasParentQuery("flow", query); !reflect.DeepEqual(q, expected) {
+		t.Errorf("Wrong legacy has_parent query: %v", q)
+	}
+
+	client.SetVersion(6)
+	expected = map[string]interface{}{"has_parent": map[string]interface{}{"parent_type": "flow", "query": query}}
+	if q := client.HasParentQuery("flow", query); !reflect.DeepEqual(q, expected) {
+		t.Errorf("Wrong single type has_parent query: %v", q)
+	}
+}
+
+func TestIndexChildOptions(t *testing.T) {
+	server := newRecordingServer(t)
+	defer server.Close()
+
+	client := newTestClient(t, hostOf(server.Server))
+
+	opts := IndexChildOptions{Routing: "host-1", Timestamp: time.Unix(1500000000, 0)}
+	if err := client.IndexChildWithOptions("metric", "flow-1", "", map[string]interface{}{}, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if request := server.last(t); request.query != "parent=flow-1&routing=host-1&timestamp=1500000000000" {
+		t.Errorf("Wrong child index parameters: %s", request.query)
+	}
+
+	client.SetVersion(6)
+	if err := client.IndexChildWithOptions("metric", "flow-1", "", map[string]interface{}{}, opts); err == nil {
+		t.Error("Expected an error as timestamps are not supported by single type indices")
+	}
+
+	opts.Timestamp = time.Time{}
+	if err := client.IndexChildWithOptions("metric", "flow-1", "", map[string]interface{}{}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if request := server.last(t); request.query != "routing=host-1" {
+		t.Errorf("Wrong child index parameters: %s", request.query)
+	}
+}
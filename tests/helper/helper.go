@@ -286,7 +286,7 @@ func NewGraph(t *testing.T) *graph.Graph {
 	var err error
 	switch graphBackend {
 	case "elasticsearch":
-		backend, err = graph.NewElasticSearchBackend("127.0.0.1", "9200", 10, 60, 1)
+		backend, err = graph.NewElasticSearchBackend([]string{"127.0.0.1:9200"}, 10, 60, 1, 0, 0)
 		if err == nil {
 			// need to use cache backend with ES as the indexing is async
 			backend, err = graph.NewCachedBackend(backend)
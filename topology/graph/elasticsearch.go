@@ -32,7 +32,6 @@ import (
 
 	"github.com/lebauce/elastigo/lib"
 	"github.com/skydive-project/skydive/common"
-	"github.com/skydive-project/skydive/config"
 	"github.com/skydive-project/skydive/filters"
 	"github.com/skydive-project/skydive/logging"
 	"github.com/skydive-project/skydive/storage/elasticsearch"
@@ -74,7 +73,11 @@ const graphElementMapping = `
 }
 `
 
-var ErrBadConfig = errors.New("elasticsearch : Config file is misconfigured, check elasticsearch key format")
+// ErrBadConfig is returned when the Elasticsearch hosts are misconfigured.
+//
+// Deprecated: use elasticsearch.ErrBadConfig which is returned by
+// elasticsearch.ParseHosts
+var ErrBadConfig = elasticsearch.ErrBadConfig
 
 type ElasticSearchBackend struct {
 	client *elasticsearch.ElasticSearchClient
@@ -504,32 +507,34 @@ func (b *ElasticSearchBackend) WithContext(graph *Graph, context GraphContext) (
 	}, nil
 }
 
-func NewElasticSearchBackend(addr string, port string, maxConns int, retrySeconds int, bulkMaxDocs int) (*ElasticSearchBackend, error) {
-	client, err := elasticsearch.NewElasticSearchClient(addr, port, maxConns, retrySeconds, bulkMaxDocs)
-	if err != nil {
-		return nil, err
-	}
-
-	client.Start([]map[string][]byte{
+func newElasticSearchBackend(client *elasticsearch.ElasticSearchClient) (*ElasticSearchBackend, error) {
+	err := client.Start([]map[string][]byte{
 		{"node": []byte(graphElementMapping)},
 		{"edge": []byte(graphElementMapping)},
 	})
+	if err != nil {
+		return nil, err
+	}
 
 	return &ElasticSearchBackend{
 		client: client,
 	}, nil
 }
 
-func NewElasticSearchBackendFromConfig() (*ElasticSearchBackend, error) {
-	addr := config.GetConfig().GetString("storage.elasticsearch.host")
-	c := strings.Split(addr, ":")
-	if len(c) != 2 {
-		return nil, ErrBadConfig
+func NewElasticSearchBackend(hosts []string, maxConns int, retrySeconds int, bulkMaxDocs int, bulkMaxBuffer int, bulkFlushInterval time.Duration) (*ElasticSearchBackend, error) {
+	client, err := elasticsearch.NewElasticSearchClient(hosts, maxConns, retrySeconds, bulkMaxDocs, bulkMaxBuffer, bulkFlushInterval)
+	if err != nil {
+		return nil, err
 	}
 
-	maxConns := config.GetConfig().GetInt("storage.elasticsearch.maxconns")
-	retrySeconds := config.GetConfig().GetInt("storage.elasticsearch.retry")
-	bulkMaxDocs := config.GetConfig().GetInt("storage.elasticsearch.bulk_maxdocs")
+	return newElasticSearchBackend(client)
+}
+
+func NewElasticSearchBackendFromConfig() (*ElasticSearchBackend, error) {
+	client, err := elasticsearch.NewElasticSearchClientFromConfig()
+	if err != nil {
+		return nil, err
+	}
 
-	return NewElasticSearchBackend(c[0], c[1], maxConns, retrySeconds, bulkMaxDocs)
+	return newElasticSearchBackend(client)
 }
@@ -189,6 +189,14 @@ func (e *graphElement) GetFieldInt64(field string) (_ int64, err error) {
 	return common.ToInt64(f)
 }
 
+func (e *graphElement) GetFieldFloat64(field string) (_ float64, err error) {
+	f, found := e.GetField(field)
+	if !found {
+		return 0, common.ErrFieldNotFound
+	}
+	return common.ToFloat64(f)
+}
+
 func (e *graphElement) GetFieldString(field string) (_ string, err error) {
 	f, found := e.GetField(field)
 	if !found {
@@ -201,6 +209,18 @@ func (e *graphElement) GetFieldString(field string) (_ string, err error) {
 	return s, nil
 }
 
+func (e *graphElement) GetFieldBool(field string) (_ bool, err error) {
+	f, found := e.GetField(field)
+	if !found {
+		return false, common.ErrFieldNotFound
+	}
+	b, ok := f.(bool)
+	if !ok {
+		return false, common.ErrFieldNotFound
+	}
+	return b, nil
+}
+
 func (e *graphElement) GetField(name string) (interface{}, bool) {
 	switch name {
 	case "ID":